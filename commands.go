@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mberlanda/outdated_branches/utils"
+)
+
+// command is a subcommand of the CLI. setup declares its flags, bound to the
+// config which already holds the environment and the config file, and
+// returns what runs once they are parsed.
+type command struct {
+	name    string
+	summary string
+	setup   func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex)
+}
+
+// listValue is a comma separated list flag
+type listValue struct {
+	list *[]string
+}
+
+func (l listValue) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l listValue) Set(value string) error {
+	xs := []string{}
+	for _, x := range strings.Split(value, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			xs = append(xs, x)
+		}
+	}
+	*l.list = xs
+	return nil
+}
+
+func requireToken(config *utils.Config) {
+	if config.Token() == "" {
+		utils.Fatal("You need to export GITHUB_OAUTH_TOKEN (or the token of the PROVIDER) env variable")
+	}
+}
+
+// detailFlags enable the optional data of the report, Github only
+func detailFlags(fs *flag.FlagSet, config *utils.Config) {
+	fs.BoolVar(&config.PredictConflicts, "predict-conflicts", config.PredictConflicts, "predict the conflicts of the PRs behind their base (PREDICT_CONFLICTS)")
+	fs.BoolVar(&config.ReviewStatus, "reviews", config.ReviewStatus, "retrieve the reviews of the PRs (REVIEW_STATUS)")
+	fs.BoolVar(&config.CIStatus, "ci", config.CIStatus, "retrieve the CI status of the PR heads (CI_STATUS)")
+	fs.BoolVar(&config.Codeowners, "codeowners", config.Codeowners, "match the changed files with CODEOWNERS (CODEOWNERS)")
+	fs.StringVar(&config.CodeownersPath, "codeowners-path", config.CodeownersPath, "local CODEOWNERS file, the one of the repository when empty (CODEOWNERS_PATH)")
+	fs.BoolVar(&config.CompareDetails, "compare-details", config.CompareDetails, "list the changed files and missing commits, JSON only (COMPARE_DETAILS)")
+}
+
+func filterFlag(fs *flag.FlagSet, config *utils.Config) {
+	fs.StringVar(&config.ReportFilter, "filter", config.ReportFilter, "keep only the PRs matching one of "+strings.Join(utils.ReportFilterNames(), ", ")+" (REPORT_FILTER)")
+}
+
+var commands = []command{
+	{"report", "report how far behind their base the open PRs are (default)", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		detailFlags(fs, config)
+		filterFlag(fs, config)
+		fs.StringVar(&config.GroupBy, "group-by", config.GroupBy, "group the PRs by milestone or stack (GROUP_BY)")
+		fs.IntVar(&config.MilestoneDueDays, "milestone-due-days", config.MilestoneDueDays, "flag the milestones due within these days (MILESTONE_DUE_DAYS)")
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			runReport(app)
+		}
+	}},
+	{"branches", "report how stale the branches are, and clean them up (Github only)", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		cleanup := fs.Bool("cleanup", false, "delete the merged and abandoned branches, after a confirmation")
+		fs.BoolVar(&config.CleanupDryRun, "dry-run", config.CleanupDryRun, "only list the branches to delete (CLEANUP_DRY_RUN)")
+		fs.IntVar(&config.CleanupMaxAgeDays, "max-age-days", config.CleanupMaxAgeDays, "age of the last commit of an abandoned branch (CLEANUP_MAX_AGE_DAYS)")
+		fs.Var(listValue{&config.CleanupAllowlist}, "allow", "comma separated patterns of the branches never deleted (CLEANUP_ALLOWLIST)")
+		fs.StringVar(&config.CleanupLogPath, "log", config.CleanupLogPath, "file recording the deleted branches (CLEANUP_LOG)")
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			if *cleanup {
+				runCleanup(app)
+			} else {
+				runBranches(app)
+			}
+		}
+	}},
+	{"actions", "label or comment the PRs behind their base", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		detailFlags(fs, config)
+		filterFlag(fs, config)
+		minBehind := fs.Int("min-behind", 1, "act on the PRs at least this many commits behind")
+		label := fs.Bool("label", false, "add the labels")
+		comment := fs.Bool("comment", false, "post the comment")
+		dryRun := fs.Bool("dry-run", false, "only list the PRs to act on")
+		yes := fs.Bool("yes", false, "do not ask for a confirmation")
+		fs.Var(listValue{&config.ActionLabels}, "labels", "comma separated labels to add (ACTION_LABELS)")
+		fs.StringVar(&config.ActionComment, "message", config.ActionComment, "comment to post, with the {author}, {number}, {behind}, {ahead}, {base} and {branch} placeholders (ACTION_COMMENT)")
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			runActions(app, *minBehind, *label, *comment, *dryRun, *yes)
+		}
+	}},
+	{"history", "show the weekly trends of the recorded runs, or the diff with the last one", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		diff := fs.Bool("diff", false, "compare the PRs with the last recorded run, and record this one")
+		fs.IntVar(&config.DiffBehindThreshold, "threshold", config.DiffBehindThreshold, "commits behind from which a PR is outdated in the diff (DIFF_BEHIND_THRESHOLD)")
+		fs.StringVar(&config.HistoryPath, "path", config.HistoryPath, "BoltDB file recording the runs, the history being disabled when empty (HISTORY_PATH)")
+		return func(app *utils.AppMutex) {
+			if *diff {
+				requireToken(app.Config)
+				runDiff(app)
+			} else {
+				runTrends(app.Config)
+			}
+		}
+	}},
+	{"serve", "serve the dashboard, the JSON API and the metrics", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		fs.StringVar(&config.ServeAddr, "addr", config.ServeAddr, "address to listen on (SERVE_ADDR)")
+		fs.IntVar(&config.ServeIntervalMinutes, "interval", config.ServeIntervalMinutes, "minutes between two refreshes, at least 1 (SERVE_INTERVAL_MINUTES)")
+		fs.BoolVar(&config.ServeBranches, "branches", config.ServeBranches, "also report the branches, Github only (SERVE_BRANCHES)")
+		fs.Var(listValue{&config.Repos}, "repos", "comma separated author/name repositories, the configured one when empty (REPOS)")
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			runServe(app.Config)
+		}
+	}},
+	{"doctor", "check the configuration and the services it points to", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		return runDoctor
+	}},
+	{"release", "report the PRs targeting the release branches (Github only)", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		fs.Var(listValue{&config.ReleaseBranches}, "branches", "comma separated patterns of the release branches (RELEASE_BRANCHES)")
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			runRelease(app)
+		}
+	}},
+	{"backports", "list the merged PRs missing from their release branch (Github only)", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		fs.IntVar(&config.BackportDays, "days", config.BackportDays, "go through the PRs merged in these last days (BACKPORT_DAYS)")
+		fs.StringVar(&config.BackportLabelPrefix, "label-prefix", config.BackportLabelPrefix, "prefix of the backport labels (BACKPORT_LABEL_PREFIX)")
+		fs.StringVar(&config.BackportBranch, "branch", config.BackportBranch, "release branch of the labels, * being the rest of the label (BACKPORT_BRANCH)")
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			runBackports(app)
+		}
+	}},
+	{"tui", "browse the report in the terminal", func(fs *flag.FlagSet, config *utils.Config) func(app *utils.AppMutex) {
+		detailFlags(fs, config)
+		return func(app *utils.AppMutex) {
+			requireToken(app.Config)
+			runTUI(app)
+		}
+	}},
+}
+
+// aliases keep the commands of the previous versions working
+var aliases = map[string][]string{
+	"cleanup": {"branches", "-cleanup"},
+	"trends":  {"history"},
+	"diff":    {"history", "-diff"},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func globalUsage(global *flag.FlagSet) func() {
+	return func() {
+		w := global.Output()
+		fmt.Fprintln(w, "Usage: outdated_branches [global flags] [command] [flags]")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Commands:")
+		for _, c := range commands {
+			fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Global flags:")
+		global.PrintDefaults()
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Run outdated_branches <command> -help for the flags of a command.")
+	}
+}
+
+func commandUsage(fs *flag.FlagSet, c command) func() {
+	return func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: outdated_branches [global flags] %s [flags]\n\n", c.name)
+		fmt.Fprintln(w, strings.ToUpper(c.summary[:1])+c.summary[1:]+".")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Flags:")
+		fs.PrintDefaults()
+	}
+}
+
+// parseCommandLine applies the global flags and the flags of the command to
+// the config, and returns what runs the command
+func parseCommandLine(args []string, config *utils.Config) func(app *utils.AppMutex) {
+	global := flag.NewFlagSet("outdated_branches", flag.ExitOnError)
+	repo := global.String("repo", "", "author/name of the repository (REPO_AUTHOR and REPO_NAME)")
+	configPath := global.String("config", "", "JSON file overriding the environment, with the keys of the json tags of utils.Config")
+	output := global.String("output", "", "output format, one of "+strings.Join(utils.OutputFormats, ", ")+" (OUTPUT_FORMAT)")
+	logFormat := global.String("log-format", "", "format of the logs written to stderr, text or json (LOG_FORMAT)")
+	verbose := global.Bool("verbose", false, "log at the debug level, every API request with its duration included (LOG_LEVEL=debug)")
+	quiet := global.Bool("quiet", false, "only log the errors (LOG_LEVEL=error)")
+	global.Usage = globalUsage(global)
+	global.Parse(args)
+
+	if *configPath != "" {
+		if err := utils.LoadConfigFile(*configPath, config); err != nil {
+			utils.Fatal("Could not load the config file", "error", err.Error())
+		}
+	}
+	if *repo != "" {
+		if !strings.Contains(*repo, "/") {
+			utils.Fatal("-repo must be author/name", "repo", *repo)
+		}
+		*config = config.ForRepo(*repo)
+	}
+	if *output != "" {
+		config.OutputFormat = *output
+	}
+	if *logFormat != "" {
+		config.LogFormat = *logFormat
+	}
+	switch {
+	case *verbose && *quiet:
+		utils.Fatal("-verbose and -quiet cannot be combined")
+	case *verbose:
+		config.LogLevel = "debug"
+	case *quiet:
+		config.LogLevel = "error"
+	}
+	if err := utils.SetupLogging(os.Stderr, config.LogLevel, config.LogFormat); err != nil {
+		utils.Fatal("Could not set up the logs", "error", err.Error())
+	}
+
+	name, rest := "report", global.Args()
+	if len(rest) > 0 {
+		name, rest = rest[0], rest[1:]
+	}
+	if alias, found := aliases[name]; found {
+		name, rest = alias[0], append(alias[1:], rest...)
+	}
+	c, found := findCommand(name)
+	if !found {
+		fmt.Fprintln(global.Output(), "Unknown command: "+name)
+		global.Usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = commandUsage(fs, c)
+	run := c.setup(fs, config)
+	fs.Parse(rest)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(fs.Output(), "Unexpected arguments: "+strings.Join(fs.Args(), " "))
+		fs.Usage()
+		os.Exit(2)
+	}
+	return run
+}
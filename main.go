@@ -1,57 +1,438 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
-	"log"
-	"strconv"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/mberlanda/outdated_branches/utils"
-	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
 )
 
 func main() {
-	log.Print("Started")
-
 	config := utils.NewConfigFromEnv()
-	if config.OauthToken == "" {
-		log.Fatal("You need to export GITHUB_OAUTH_TOKEN env variable")
-	}
+	run := parseCommandLine(os.Args[1:], &config)
+	start := time.Now()
+	slog.Info("Started", "repo", config.Repo())
 
 	app := utils.MakeAppWithDefaults()
 	app.Config = &config
+	run(&app)
+
+	slog.Info("Finished", "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// confirm asks a yes or no question on stdin, no being the default. The
+// question goes to stderr, so that stdout only carries the report.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(answer)) == "y"
+}
+
+// checkDetailsSupported stops before any request when an optional column is
+// enabled on a provider which does not support it
+func checkDetailsSupported(config *utils.Config) {
+	if config.Provider == "github" {
+		if config.CompareDetails && config.OutputFormat != "json" {
+			utils.Fatal("Compare details are only supported on Github with OUTPUT_FORMAT=json")
+		}
+		return
+	}
+	supportedOnGithub := []struct {
+		enabled bool
+		name    string
+	}{
+		{config.PredictConflicts, "Conflict prediction"},
+		{config.ReviewStatus, "Review status"},
+		{config.CIStatus, "CI status"},
+		{config.CompareDetails, "Compare details"},
+		{config.Codeowners, "CODEOWNERS routing"},
+	}
+	for _, detail := range supportedOnGithub {
+		if detail.enabled {
+			utils.Fatal(detail.name + " is only supported on Github")
+		}
+	}
+}
+
+// generateReport logs the progress of the analysis of the configured
+// repository, and returns the provider it went through for the callers
+// acting on the pull requests
+func generateReport(app *utils.AppMutex) (*utils.Report, utils.Provider) {
+	checkDetailsSupported(app.Config)
+	provider, err := utils.NewProvider(app)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	masterSha, err := provider.BranchHead("master")
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Master branch commit", "sha", masterSha)
 
-	log.Print("Master branch commit Sha: " + app.GetLastCommit("master"))
+	pullRequests, backend, err := utils.ReportSource(app, provider)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+
+	slog.Info("Retrieved the open pull requests", "count", len(pullRequests))
+
+	report, err := utils.BuildReport(app.Config.Repo(), pullRequests, backend)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Compared the pull requests with their base")
+
+	if err := app.AddDetails(report); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	return report, provider
+}
 
-	pullRequests := app.RetrievePullRequestsWithPagination(0)
+func recordHistory(config *utils.Config, report *utils.Report) {
+	if !config.HistoryEnabled() {
+		return
+	}
+	if err := (utils.HistoryStore{Path: config.HistoryPath}).Append(report); err != nil {
+		slog.Warn("Could not record the history", "error", err)
+	}
+}
 
-	log.Print(strconv.Itoa(len(pullRequests)) + " Open Pull requests")
+func runReport(app *utils.AppMutex) {
+	report, _ := generateReport(app)
+	filtered, err := utils.FilterReport(report, app.Config.ReportFilter)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	switch app.Config.GroupBy {
+	case "":
+		err = utils.WriteReport(os.Stdout, app.Config.OutputFormat, filtered)
+	case "milestone":
+		dueWithin := time.Duration(app.Config.MilestoneDueDays) * 24 * time.Hour
+		err = utils.WriteMilestones(os.Stdout, app.Config.OutputFormat, filtered, utils.GroupByMilestone(filtered, dueWithin))
+	case "stack":
+		err = utils.WriteStacks(os.Stdout, app.Config.OutputFormat, filtered)
+	default:
+		utils.Fatal("Unknown GROUP_BY", "group_by", app.Config.GroupBy)
+	}
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	recordHistory(app.Config, report)
+	notify(app, report)
+}
 
-	fmt.Println("PR ID | Branch | Base Branch | CommitDiff | Created At")
-	fmt.Println("------|--------|-------------|------------|-----------")
+// runDiff compares the report with the last run recorded in the history,
+// before recording it
+func runDiff(app *utils.AppMutex) {
+	if !app.Config.HistoryEnabled() {
+		utils.Fatal("The diff needs the history, set HISTORY_PATH")
+	}
+	runs, err := utils.HistoryStore{Path: app.Config.HistoryPath}.Runs(app.Config.Repo())
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	var previous *utils.HistoryRun
+	if len(runs) > 0 {
+		previous = &runs[len(runs)-1]
+	}
+
+	report, _ := generateReport(app)
+	diff := utils.DiffRuns(previous, report, app.Config.DiffBehindThreshold)
+	if err := utils.WriteDiff(os.Stdout, app.Config.OutputFormat, diff); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	recordHistory(app.Config, report)
+}
+
+// notify posts the digest of the report to the configured chat webhooks and
+// mailboxes
+func notify(app *utils.AppMutex, report *utils.Report) {
+	if app.Config.EmailAuthors && app.Config.SMTPHost != "" {
+		notifyAuthors(app, report)
+	}
+	notifiers := utils.NewNotifiers(app.Config, app.Client)
+	if len(notifiers) == 0 {
+		return
+	}
+	handles, err := utils.LoadLoginMap(app.Config.ChatHandlesPath)
+	if err != nil {
+		slog.Warn("Could not load chat handles", "error", err)
+	}
+	digest := utils.NewDigest(report, app.Config.NotifyTopN, handles)
+	if err := utils.NotifyAll(notifiers, digest); err != nil {
+		slog.Warn("Could not notify", "error", err)
+		return
+	}
+	slog.Info("Digest sent", "recipients", len(notifiers))
+}
+
+// notifyAuthors emails every author, and every CODEOWNERS owner, the list of
+// their outdated PRs only
+func notifyAuthors(app *utils.AppMutex, report *utils.Report) {
+	emails, err := utils.LoadLoginMap(app.Config.AuthorEmailsPath)
+	if err != nil {
+		slog.Warn("Could not load author emails", "error", err)
+	}
+	notifier := utils.NewEmailNotifier(app.Config)
+	for author, rows := range utils.OutdatedByAuthor(report) {
+		email, err := app.AuthorEmail(emails, rows[0])
+		if err != nil {
+			slog.Warn("Could not email", "author", author, "error", err)
+			continue
+		}
+		if err := notifier.NotifyAuthor(email, report.Repo, rows); err != nil {
+			slog.Warn("Could not email", "author", author, "error", err)
+		}
+	}
+	for owner, rows := range utils.OutdatedByOwner(report) {
+		email, err := utils.OwnerEmail(emails, owner)
+		if err != nil {
+			slog.Warn("Could not email", "owner", owner, "error", err)
+			continue
+		}
+		if err := notifier.NotifyOwner(email, owner, report.Repo, rows); err != nil {
+			slog.Warn("Could not email", "owner", owner, "error", err)
+		}
+	}
+}
+
+// runRelease reports the PRs targeting the release branches, Github only
+func runRelease(app *utils.AppMutex) {
+	if app.Config.Provider != "github" {
+		utils.Fatal("Release mode is only supported on Github")
+	}
+	pullRequests, err := app.RetrievePullRequestsWithPagination(0)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	branches, err := app.RetrieveBranchesWithPagination(0)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Retrieved the branches and open pull requests", "branches", len(branches), "pull_requests", len(pullRequests))
+
+	report, err := app.BuildReleaseReport(branches, pullRequests)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Found the release branches", "count", len(report.Branches), "patterns", strings.Join(app.Config.ReleaseBranches, ","))
+	if err := utils.WriteRelease(os.Stdout, app.Config.OutputFormat, report); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+}
+
+// runBackports lists the merged PRs labelled for a backport which is still
+// missing from their release branch, Github only
+func runBackports(app *utils.AppMutex) {
+	if app.Config.Provider != "github" {
+		utils.Fatal("The backport tracker is only supported on Github")
+	}
+	report, err := app.BuildBackportReport(app.Config.BackportDays)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	if err := utils.WriteBackports(os.Stdout, app.Config.OutputFormat, report); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+}
 
-	eg := errgroup.Group{}
-	for _, pr := range pullRequests {
-		prID := "#" + strconv.Itoa(pr.Number)
-		prCreatedAt := pr.CreatedAt.Format(time.UnixDate)
-		headRef := pr.Head.Ref
-		headSha := app.GetLastCommit(headRef)
-		baseRef := pr.Base.Ref
-		baseSha := pr.Base.Sha
-		eg.Go(func() error {
-			compareCommit, errCompare := app.CompareCommits(baseSha, headSha)
-			if errCompare == nil {
-				fmt.Println(prID + " | " + headRef + " | " + baseRef + " | " + strconv.Itoa(compareCommit.TotalCommits) + " | " + prCreatedAt)
+// runTUI browses the report in the terminal, the actions being run through
+// the provider
+func runTUI(app *utils.AppMutex) {
+	report, provider := generateReport(app)
+	tui := utils.NewTUI(report, app.Config, provider)
+	if app.Config.Provider == "github" {
+		tui.MissingCommits = func(row utils.ReportRow) ([]utils.GithubCommit, error) {
+			compare, err := app.CompareCommitsFull(row.HeadSha, row.BaseRef)
+			if err != nil {
+				return nil, err
 			}
-			return errCompare
-		})
+			return compare.Commits, nil
+		}
+	}
+
+	term, restore, err := utils.OpenTTY()
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	err = tui.Run(term)
+	restore()
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+}
+
+// runBranches reports how far every branch is from the default branch,
+// Github only
+func runBranches(app *utils.AppMutex) {
+	report, err := utils.GenerateBranchReport(app.Config)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Compared the branches", "count", len(report.Rows), "default_branch", report.DefaultBranch)
+	if err := utils.WriteBranchReport(os.Stdout, app.Config.OutputFormat, report); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+}
+
+func runCleanup(app *utils.AppMutex) {
+	defaultBranch, err := app.GetDefaultBranch()
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Default branch", "branch", defaultBranch)
+
+	pullRequests, err := app.RetrievePullRequestsWithPagination(0)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	branches, err := app.RetrieveBranchesWithPagination(0)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Retrieved the branches and open pull requests", "branches", len(branches), "pull_requests", len(pullRequests))
+
+	policy := utils.NewCleanupPolicy(app.Config, defaultBranch, pullRequests)
+	candidates, err := app.FindCleanupCandidates(policy, branches)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+
+	if err := utils.WriteCleanupCandidates(os.Stdout, app.Config.OutputFormat, candidates); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+
+	if len(candidates) == 0 || app.Config.CleanupDryRun {
+		slog.Info("Dry run: no branch deleted")
+		return
+	}
+
+	if !confirm(fmt.Sprintf("Delete %d branches?", len(candidates))) {
+		slog.Info("Aborted: no branch deleted")
+		return
 	}
-	if err := eg.Wait(); err == nil {
-		log.Print("Successfully retrieved all PRs.")
+
+	for _, c := range candidates {
+		if err := app.DeleteBranch(c.Branch); err != nil {
+			slog.Warn("Could not delete", "branch", c.Branch, "error", err)
+			continue
+		}
+		c.DeletedAt = time.Now()
+		if err := utils.AppendCleanupLog(app.Config.CleanupLogPath, c); err != nil {
+			utils.Fatal("Could not write the cleanup log", "error", err)
+		}
+		slog.Info("Deleted", "branch", c.Branch, "sha", c.Sha)
+	}
+}
+
+func runServe(config *utils.Config) {
+	snapshot := utils.NewSnapshot(config)
+	go snapshot.Run()
+
+	mux := http.NewServeMux()
+	dashboard := utils.Dashboard{Snapshot: snapshot}
+	dashboard.Register(mux)
+	exporter := utils.MetricsExporter{Snapshot: snapshot, Metrics: utils.AppMetrics}
+	exporter.Register(mux)
+	if config.WebhookSecret != "" {
+		receiver := utils.WebhookReceiver{Snapshot: snapshot, Secret: config.WebhookSecret}
+		receiver.Register(mux)
+	}
+	if config.APIToken != "" {
+		api := utils.API{Snapshot: snapshot, Token: config.APIToken}
+		api.Register(mux)
 	} else {
-		log.Fatal(errors.Wrap(err, "Received error:"))
+		slog.Info("API_TOKEN is not set, the JSON API is disabled")
+	}
+
+	slog.Info("Serving the dashboard", "addr", config.ServeAddr)
+	utils.Fatal("Could not serve", "error", http.ListenAndServe(config.ServeAddr, mux))
+}
+
+func runTrends(config *utils.Config) {
+	if !config.HistoryEnabled() {
+		utils.Fatal("The trends need the history, set HISTORY_PATH")
+	}
+	runs, err := utils.HistoryStore{Path: config.HistoryPath}.Runs(config.Repo())
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	slog.Info("Read the recorded runs", "count", len(runs))
+
+	if err := utils.WriteTrends(os.Stdout, config.OutputFormat, utils.WeeklyTrends(runs)); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+}
+
+// runActions adds the ACTION_LABELS and posts the ACTION_COMMENT on the PRs
+// at least minBehind commits behind their base, once confirmed
+func runActions(app *utils.AppMutex, minBehind int, label bool, comment bool, dryRun bool, yes bool) {
+	if !label && !comment {
+		utils.Fatal("Nothing to do, pass -label and/or -comment")
+	}
+	report, provider := generateReport(app)
+	filtered, err := utils.FilterReport(report, app.Config.ReportFilter)
+	if err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	rows := []utils.ReportRow{}
+	for _, row := range filtered.Rows {
+		if row.BehindBy >= minBehind {
+			rows = append(rows, row)
+		}
 	}
 
-	log.Print("Finished")
+	actions := []string{}
+	if label {
+		actions = append(actions, "label "+strings.Join(app.Config.ActionLabels, ", "))
+	}
+	if comment {
+		actions = append(actions, "comment")
+	}
+	if err := utils.WriteActionPlan(os.Stdout, app.Config.OutputFormat, rows, actions); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+
+	if len(rows) == 0 || dryRun {
+		slog.Info("Dry run: no PR changed")
+		return
+	}
+	if !yes && !confirm(fmt.Sprintf("Act on %d PRs?", len(rows))) {
+		slog.Info("Aborted: no PR changed")
+		return
+	}
+	for _, row := range rows {
+		if label {
+			if err := utils.LabelPullRequest(provider, app.Config, row); err != nil {
+				slog.Warn("Could not label", "number", row.Number, "error", err)
+			} else {
+				slog.Info("Labelled", "number", row.Number)
+			}
+		}
+		if comment {
+			if err := utils.CommentPullRequest(provider, app.Config, row); err != nil {
+				slog.Warn("Could not comment", "number", row.Number, "error", err)
+			} else {
+				slog.Info("Commented", "number", row.Number)
+			}
+		}
+	}
+}
+
+// runDoctor exits with 1 when one of the checks failed
+func runDoctor(app *utils.AppMutex) {
+	checks := app.Doctor()
+	if err := utils.WriteDoctor(os.Stdout, app.Config.OutputFormat, checks); err != nil {
+		utils.Fatal("Received error", "error", err)
+	}
+	for _, check := range checks {
+		if !check.OK() {
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ActionComment fills the placeholders of the ACTION_COMMENT template:
+// {author}, {number}, {behind}, {ahead}, {base} and {branch}
+func ActionComment(template string, row ReportRow) string {
+	return strings.NewReplacer(
+		"{author}", row.Author,
+		"{number}", strconv.Itoa(row.Number),
+		"{behind}", strconv.Itoa(row.BehindBy),
+		"{ahead}", strconv.Itoa(row.AheadBy),
+		"{base}", row.BaseRef,
+		"{branch}", row.HeadRef,
+	).Replace(template)
+}
+
+// LabelPullRequest adds the ACTION_LABELS to the pull request
+func LabelPullRequest(provider Provider, config *Config, row ReportRow) error {
+	return provider.AddLabels(row.Number, config.ActionLabels)
+}
+
+// CommentPullRequest posts the ACTION_COMMENT on the pull request
+func CommentPullRequest(provider Provider, config *Config, row ReportRow) error {
+	return provider.AddComment(row.Number, ActionComment(config.ActionComment, row))
+}
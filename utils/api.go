@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// API serves the snapshot as JSON to the clients presenting the static
+// bearer token
+type API struct {
+	Snapshot *Snapshot
+	Token    string
+}
+
+func (api *API) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/repos/", api.serveRepos)
+}
+
+func (api *API) authorized(r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	if api.Token == "" || !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// serveRepos routes /repos/{owner}/{name}/pulls and
+// /repos/{owner}/{name}/branches
+func (api *API) serveRepos(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(r) {
+		writeJSONError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+		return
+	}
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/repos/"), "/"), "/")
+	if len(parts) != 3 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	repo := parts[0] + "/" + parts[1]
+	query := r.URL.Query()
+
+	switch parts[2] {
+	case "pulls":
+		report := api.Snapshot.Report(repo)
+		if report == nil {
+			writeJSONError(w, http.StatusNotFound, "no report for "+repo)
+			return
+		}
+		report, err := FilterReport(report, query.Get("filter"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rows := []ReportRow{}
+		for _, row := range report.Rows {
+			if matchPull(row, query) {
+				rows = append(rows, row)
+			}
+		}
+		filtered := *report
+		filtered.Rows = rows
+		writeJSON(w, http.StatusOK, filtered)
+	case "branches":
+		report := api.Snapshot.BranchReport(repo)
+		if report == nil {
+			writeJSONError(w, http.StatusNotFound, "no branch report for "+repo)
+			return
+		}
+		rows := []BranchRow{}
+		for _, row := range report.Rows {
+			if matchBranch(row, query) {
+				rows = append(rows, row)
+			}
+		}
+		writeJSON(w, http.StatusOK, BranchReport{Repo: report.Repo, DefaultBranch: report.DefaultBranch, GeneratedAt: report.GeneratedAt, Rows: rows})
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// atLeast and atMost ignore the parameters that are missing or not numbers
+func atLeast(query url.Values, key string, value int) bool {
+	min, err := strconv.Atoi(query.Get(key))
+	return err != nil || value >= min
+}
+
+func atMost(query url.Values, key string, value int) bool {
+	max, err := strconv.Atoi(query.Get(key))
+	return err != nil || value <= max
+}
+
+func equalsIfSet(query url.Values, key string, value string) bool {
+	expected := query.Get(key)
+	return expected == "" || expected == value
+}
+
+// matchPull filters on author, base, status, min_behind and max_behind, the
+// filter parameter being one of the ReportFilters
+func matchPull(row ReportRow, query url.Values) bool {
+	return equalsIfSet(query, "author", row.Author) &&
+		equalsIfSet(query, "base", row.BaseRef) &&
+		equalsIfSet(query, "status", row.Status) &&
+		atLeast(query, "min_behind", row.BehindBy) &&
+		atMost(query, "max_behind", row.BehindBy)
+}
+
+// matchBranch filters on status, protected, has_open_pr, min_behind and
+// min_age_days
+func matchBranch(row BranchRow, query url.Values) bool {
+	return equalsIfSet(query, "status", row.Status) &&
+		equalsIfSet(query, "protected", strconv.FormatBool(row.Protected)) &&
+		equalsIfSet(query, "has_open_pr", strconv.FormatBool(row.HasOpenPR)) &&
+		atLeast(query, "min_behind", row.BehindBy) &&
+		atLeast(query, "min_age_days", row.AgeDays)
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAPIServer serves a snapshot of octo/repo with four pull requests and
+// four branches
+func newAPIServer(t *testing.T, token string) *httptest.Server {
+	snapshot := NewSnapshot(&Config{RepoAuthor: "octo", RepoName: "repo"})
+	snapshot.reports = []*Report{{Repo: "octo/repo", CIFetched: true, Rows: []ReportRow{
+		{Number: 1, Author: "alice", BaseRef: "master", Status: "ahead", CIStatus: "success"},
+		{Number: 2, Author: "bob", BaseRef: "master", Status: "diverged", BehindBy: 5, CIStatus: "failure"},
+		{Number: 3, Author: "alice", BaseRef: "develop", Status: "diverged", BehindBy: 20, CIStatus: "success"},
+		{Number: 4, Author: "carol", BaseRef: "master", Status: "behind", BehindBy: 60, CIStatus: "pending"},
+	}}}
+	snapshot.branchReports = []*BranchReport{{Repo: "octo/repo", DefaultBranch: "master", Rows: []BranchRow{
+		{Branch: "master", Protected: true, Status: "identical"},
+		{Branch: "feature", HasOpenPR: true, Status: "diverged", BehindBy: 3, AgeDays: 2},
+		{Branch: "merged", Status: "behind", BehindBy: 40, AgeDays: 100},
+		{Branch: "stale", Status: "diverged", BehindBy: 12, AgeDays: 200},
+	}}}
+	mux := http.NewServeMux()
+	(&API{Snapshot: snapshot, Token: token}).Register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func apiGet(t *testing.T, server *httptest.Server, path string, authorization string) *http.Response {
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAPIBearerToken(t *testing.T) {
+	server := newAPIServer(t, "api-secret")
+	for _, test := range []struct {
+		authorization string
+		status        int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer api-secret-and-more", http.StatusUnauthorized},
+		{"api-secret", http.StatusUnauthorized},
+		{"token api-secret", http.StatusUnauthorized},
+		{"Bearer api-secret", http.StatusOK},
+	} {
+		if resp := apiGet(t, server, "/repos/octo/repo/pulls", test.authorization); resp.StatusCode != test.status {
+			t.Errorf("Authorization %q answered %d, want %d", test.authorization, resp.StatusCode, test.status)
+		}
+	}
+
+	// without a token configured, nobody is authorized
+	server = newAPIServer(t, "")
+	if resp := apiGet(t, server, "/repos/octo/repo/pulls", "Bearer "); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("an empty token answered %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestAPIPullFilters(t *testing.T) {
+	server := newAPIServer(t, "api-secret")
+	for _, test := range []struct {
+		query   string
+		numbers string
+	}{
+		{"", "1 2 3 4"},
+		{"min_behind=5", "2 3 4"},
+		{"max_behind=20", "1 2 3"},
+		{"min_behind=5&max_behind=20", "2 3"},
+		{"min_behind=not-a-number", "1 2 3 4"},
+		{"status=diverged", "2 3"},
+		{"author=alice&base=master", "1"},
+		{"filter=ci-success&min_behind=1", "3"},
+	} {
+		resp := apiGet(t, server, "/repos/octo/repo/pulls?"+test.query, "Bearer api-secret")
+		report := Report{}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		numbers := []string{}
+		for _, row := range report.Rows {
+			numbers = append(numbers, fmt.Sprint(row.Number))
+		}
+		if got := strings.Join(numbers, " "); got != test.numbers {
+			t.Errorf("pulls?%s = %q, want %q", test.query, got, test.numbers)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/repos/octo/repo/pulls?filter=unknown": http.StatusBadRequest,
+		"/repos/octo/other/pulls":               http.StatusNotFound,
+		"/repos/octo/repo/issues":               http.StatusNotFound,
+	} {
+		if resp := apiGet(t, server, path, "Bearer api-secret"); resp.StatusCode != status {
+			t.Errorf("%s answered %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestAPIBranchFilters(t *testing.T) {
+	server := newAPIServer(t, "api-secret")
+	for _, test := range []struct {
+		query    string
+		branches string
+	}{
+		{"", "master feature merged stale"},
+		{"status=diverged", "feature stale"},
+		{"has_open_pr=true", "feature"},
+		{"has_open_pr=false&protected=false", "merged stale"},
+		{"min_behind=12", "merged stale"},
+		{"min_age_days=100", "merged stale"},
+		{"min_age_days=100&status=behind", "merged"},
+	} {
+		resp := apiGet(t, server, "/repos/octo/repo/branches?"+test.query, "Bearer api-secret")
+		report := BranchReport{}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		branches := []string{}
+		for _, row := range report.Rows {
+			branches = append(branches, row.Branch)
+		}
+		if got := strings.Join(branches, " "); got != test.branches {
+			t.Errorf("branches?%s = %q, want %q", test.query, got, test.branches)
+		}
+	}
+}
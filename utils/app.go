@@ -1,16 +1,24 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// maxConcurrentRequests bounds the goroutines of the fan-outs with a call
+// per branch or pull request, which may be thousands
+const maxConcurrentRequests = 10
+
 type PullRequestList []GithubPullRequest
 
 func (xs PullRequestList) concat(ys PullRequestList) PullRequestList {
@@ -20,6 +28,15 @@ func (xs PullRequestList) concat(ys PullRequestList) PullRequestList {
 	return xs
 }
 
+type BranchList []GithubBranch
+
+func (xs BranchList) concat(ys BranchList) BranchList {
+	for _, y := range ys {
+		xs = append(xs, y)
+	}
+	return xs
+}
+
 type AppMutex struct {
 	lock          sync.Mutex
 	BaseBranchMap map[string]string
@@ -41,41 +58,451 @@ func (a *AppMutex) ApiOpenPullRequests(page int) *http.Request {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls?state=open&page=%s", a.Config.RepoAuthor, a.Config.RepoName, strconv.Itoa(page))
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "ApiOpenPullRequests: "))
+		Fatal("ApiOpenPullRequests", "error", err)
 	}
 	return req
 }
 
-func (a *AppMutex) ApiHeadBranch(branch string) *http.Request {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches/%s", a.Config.RepoAuthor, a.Config.RepoName, branch)
+// escapeBranch escapes every segment of a branch name, keeping the slashes
+// between them: a "#" or a "%" would otherwise target another branch
+func escapeBranch(branch string) string {
+	segments := strings.Split(branch, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func (a *AppMutex) ApiHeadBranch(branch string) (*http.Request, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches/%s", a.Config.RepoAuthor, a.Config.RepoName, escapeBranch(branch))
+	return http.NewRequest("GET", url, nil)
+}
+
+func (a *AppMutex) ApiCommit(sha string) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s", a.Config.RepoAuthor, a.Config.RepoName, sha)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "apiHeadBranch: "))
+		Fatal("apiCommit", "error", err)
 	}
 	return req
 }
 
 func (a *AppMutex) ApiCommitCompare(base string, merge string) *http.Request {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/compare/%s...%s", a.Config.RepoAuthor, a.Config.RepoName, base, merge)
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/compare/%s...%s", a.Config.RepoAuthor, a.Config.RepoName, escapeBranch(base), escapeBranch(merge))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiCommitCompare", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiCommitComparePage(base string, merge string, page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/compare/%s...%s?per_page=100&page=%d", a.Config.RepoAuthor, a.Config.RepoName, escapeBranch(base), escapeBranch(merge), page)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "apiCommitCompare: "))
+		Fatal("apiCommitComparePage", "error", err)
 	}
 	return req
 }
 
-func (a *AppMutex) RetrievePullRequestsWithPagination(page int) PullRequestList {
+func (a *AppMutex) ApiRepository() *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s", a.Config.RepoAuthor, a.Config.RepoName)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiRepository", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiBranches(page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches?per_page=100&page=%s", a.Config.RepoAuthor, a.Config.RepoName, strconv.Itoa(page))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiBranches", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiDeleteBranch(branch string) (*http.Request, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs/heads/%s", a.Config.RepoAuthor, a.Config.RepoName, escapeBranch(branch))
+	return http.NewRequest("DELETE", url, nil)
+}
+
+func (a *AppMutex) ApiIssueLabels(number int, labels []string) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/labels", a.Config.RepoAuthor, a.Config.RepoName, number)
+	body, _ := json.Marshal(map[string][]string{"labels": labels})
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		Fatal("apiIssueLabels", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiPullRequestReviews(number int, page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/reviews?per_page=100&page=%d", a.Config.RepoAuthor, a.Config.RepoName, number, page)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiPullRequestReviews", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiCombinedStatus(sha string) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s/status", a.Config.RepoAuthor, a.Config.RepoName, sha)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiCombinedStatus", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiCheckRuns(sha string, page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s/check-runs?per_page=100&page=%d", a.Config.RepoAuthor, a.Config.RepoName, sha, page)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiCheckRuns", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiPullRequestFiles(number int, page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/files?per_page=100&page=%d", a.Config.RepoAuthor, a.Config.RepoName, number, page)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiPullRequestFiles", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiContent(path string) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", a.Config.RepoAuthor, a.Config.RepoName, path)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiContent", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiClosedPullRequests(page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls?state=closed&sort=updated&direction=desc&per_page=100&page=%d", a.Config.RepoAuthor, a.Config.RepoName, page)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiClosedPullRequests", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiPullRequestCommits(number int, page int) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/commits?per_page=100&page=%d", a.Config.RepoAuthor, a.Config.RepoName, number, page)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiPullRequestCommits", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiBranchCommits(branch string, since time.Time, page int) *http.Request {
+	query := url.Values{"sha": {branch}, "since": {since.UTC().Format(time.RFC3339)}, "per_page": {"100"}, "page": {strconv.Itoa(page)}}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits?%s", a.Config.RepoAuthor, a.Config.RepoName, query.Encode())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		Fatal("apiBranchCommits", "error", err)
+	}
+	return req
+}
+
+func (a *AppMutex) ApiIssueComment(number int, comment string) *http.Request {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/comments", a.Config.RepoAuthor, a.Config.RepoName, number)
+	body, _ := json.Marshal(map[string]string{"body": comment})
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		Fatal("apiIssueComment", "error", err)
+	}
+	return req
+}
+
+// getPage decodes a page of a listing, an error status being returned as
+// an error instead of decoding as an empty last page
+func (a *AppMutex) getPage(req *http.Request, page interface{}) error {
+	resp, err := a.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(page)
+}
+
+func (a *AppMutex) RetrievePullRequestsWithPagination(page int) (PullRequestList, error) {
 	pullRequests := PullRequestList{}
-	respPr, errPr := a.doRequest(a.ApiOpenPullRequests(page + 1))
-	if errPr != nil {
-		log.Fatal(errors.Wrap(errPr, "retrievePullRequestsWithPagination: "))
+	if err := a.getPage(a.ApiOpenPullRequests(page+1), &pullRequests); err != nil {
+		return nil, errors.Wrap(err, "retrievePullRequestsWithPagination")
 	}
-	json.NewDecoder(respPr.Body).Decode(&pullRequests)
-	defer respPr.Body.Close()
 	if len(pullRequests) == 0 {
-		return pullRequests
+		return pullRequests, nil
+	}
+	next, err := a.RetrievePullRequestsWithPagination(page + 1)
+	if err != nil {
+		return nil, err
+	}
+	return pullRequests.concat(next), nil
+}
+
+func (a *AppMutex) RetrieveBranchesWithPagination(page int) (BranchList, error) {
+	branches := BranchList{}
+	if err := a.getPage(a.ApiBranches(page+1), &branches); err != nil {
+		return nil, errors.Wrap(err, "retrieveBranchesWithPagination")
+	}
+	if len(branches) == 0 {
+		return branches, nil
+	}
+	next, err := a.RetrieveBranchesWithPagination(page + 1)
+	if err != nil {
+		return nil, err
+	}
+	return branches.concat(next), nil
+}
+
+func (a *AppMutex) GetDefaultBranch() (string, error) {
+	repo := GithubRepo{}
+	resp, err := a.doRequest(a.ApiRepository())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		return "", err
+	}
+	return withDefault(repo.DefaultBranch, "master"), nil
+}
+
+// RequestBranch returns the full branch payload, including the date of its
+// last commit, without going through the last commit cache.
+func (a *AppMutex) RequestBranch(branchName string) (*GithubBranch, error) {
+	branch := GithubBranch{}
+	req, err := a.ApiHeadBranch(branchName)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requestBranch %s: unexpected status %s", branchName, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&branch); err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+func (a *AppMutex) RequestCommit(sha string) (*GithubCommit, error) {
+	commit := GithubCommit{}
+	resp, err := a.doRequest(a.ApiCommit(sha))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&commit); err != nil {
+		return nil, err
+	}
+	return &commit, nil
+}
+
+// RequestReviews lists the submitted reviews of a pull request, oldest first
+func (a *AppMutex) RequestReviews(number int) ([]GithubReview, error) {
+	reviews := []GithubReview{}
+	for page := 1; ; page++ {
+		pageReviews := []GithubReview{}
+		resp, err := a.doRequest(a.ApiPullRequestReviews(number, page))
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&pageReviews)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, pageReviews...)
+		if len(pageReviews) < 100 {
+			return reviews, nil
+		}
+	}
+}
+
+func (a *AppMutex) RequestCombinedStatus(sha string) (*GithubCombinedStatus, error) {
+	status := GithubCombinedStatus{}
+	resp, err := a.doRequest(a.ApiCombinedStatus(sha))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (a *AppMutex) RequestCheckRuns(sha string) ([]GithubCheckRun, error) {
+	checkRuns := []GithubCheckRun{}
+	for page := 1; ; page++ {
+		pageRuns := GithubCheckRuns{}
+		resp, err := a.doRequest(a.ApiCheckRuns(sha, page))
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&pageRuns)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		checkRuns = append(checkRuns, pageRuns.CheckRuns...)
+		if len(pageRuns.CheckRuns) == 0 || len(checkRuns) >= pageRuns.TotalCount {
+			return checkRuns, nil
+		}
+	}
+}
+
+// RequestPullRequestFiles lists the files changed by a pull request, Github
+// stops at 3000 files
+func (a *AppMutex) RequestPullRequestFiles(number int) ([]GithubFile, error) {
+	files := []GithubFile{}
+	for page := 1; ; page++ {
+		pageFiles := []GithubFile{}
+		resp, err := a.doRequest(a.ApiPullRequestFiles(number, page))
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&pageFiles)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pageFiles...)
+		if len(pageFiles) < 100 {
+			return files, nil
+		}
+	}
+}
+
+// RequestContent returns the file on the default branch, nil when it does
+// not exist
+func (a *AppMutex) RequestContent(path string) (*GithubContent, error) {
+	content := GithubContent{}
+	resp, err := a.doRequest(a.ApiContent(path))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requestContent %s: unexpected status %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// RetrieveMergedPullRequests lists the pull requests merged since the given
+// time, going through the closed ones from the most recently updated
+func (a *AppMutex) RetrieveMergedPullRequests(since time.Time) (PullRequestList, error) {
+	merged := PullRequestList{}
+	for page := 1; ; page++ {
+		pullRequests := PullRequestList{}
+		resp, err := a.doRequest(a.ApiClosedPullRequests(page))
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&pullRequests)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range pullRequests {
+			if !pr.MergedAt.IsZero() && pr.MergedAt.After(since) {
+				merged = append(merged, pr)
+			}
+		}
+		if len(pullRequests) < 100 || pullRequests[len(pullRequests)-1].UpdatedAt.Before(since) {
+			return merged, nil
+		}
+	}
+}
+
+func (a *AppMutex) requestCommitPages(request func(page int) *http.Request) ([]GithubCommit, error) {
+	commits := []GithubCommit{}
+	for page := 1; ; page++ {
+		pageCommits := []GithubCommit{}
+		resp, err := a.doRequest(request(page))
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&pageCommits)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, pageCommits...)
+		if len(pageCommits) < 100 {
+			return commits, nil
+		}
+	}
+}
+
+// RequestPullRequestCommits lists the commits of a pull request, Github stops
+// at 250 commits
+func (a *AppMutex) RequestPullRequestCommits(number int) ([]GithubCommit, error) {
+	return a.requestCommitPages(func(page int) *http.Request { return a.ApiPullRequestCommits(number, page) })
+}
+
+// RequestBranchCommits lists the commits of a branch committed since the
+// given time
+func (a *AppMutex) RequestBranchCommits(branch string, since time.Time) ([]GithubCommit, error) {
+	return a.requestCommitPages(func(page int) *http.Request { return a.ApiBranchCommits(branch, since, page) })
+}
+
+func (a *AppMutex) DeleteBranch(branchName string) error {
+	req, err := a.ApiDeleteBranch(branchName)
+	if err != nil {
+		return err
+	}
+	resp, err := a.doRequest(req)
+	if err != nil {
+		return err
 	}
-	return pullRequests.concat(a.RetrievePullRequestsWithPagination(page + 1))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("deleteBranch %s: unexpected status %s", branchName, resp.Status)
+	}
+	return nil
+}
+
+func (a *AppMutex) doWrite(req *http.Request) error {
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: unexpected status %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return nil
+}
+
+func (a *AppMutex) AddLabels(number int, labels []string) error {
+	return a.doWrite(a.ApiIssueLabels(number, labels))
+}
+
+func (a *AppMutex) AddComment(number int, comment string) error {
+	return a.doWrite(a.ApiIssueComment(number, comment))
 }
 
 func (a *AppMutex) cachedLastCommit(branchName string) (string, bool) {
@@ -85,28 +512,26 @@ func (a *AppMutex) cachedLastCommit(branchName string) (string, bool) {
 	return commit, found
 }
 
-func (a *AppMutex) RequestLastCommit(branchName string) string {
+func (a *AppMutex) RequestLastCommit(branchName string) (string, error) {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
-	resp, err := a.doRequest(a.ApiHeadBranch(branchName))
+	branch, err := a.RequestBranch(branchName)
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "requestLastCommit: "))
+		return "", errors.Wrap(err, "requestLastCommit")
 	}
-	branch := GithubBranch{}
-	json.NewDecoder(resp.Body).Decode(&branch)
 	commit := branch.Commit.Sha
-	// log.Print(fmt.Sprintf("Branch: %s | Last commit: %s", branchName, commit))
+	slog.Debug("Last commit", "branch", branchName, "sha", commit)
 	a.BaseBranchMap[branchName] = branch.Commit.Sha
-	return commit
+	return commit, nil
 }
 
-func (a *AppMutex) GetLastCommit(branchName string) string {
+func (a *AppMutex) GetLastCommit(branchName string) (string, error) {
 	commit, found := a.cachedLastCommit(branchName)
 	if !found {
 		return a.RequestLastCommit(branchName)
 	}
-	return commit
+	return commit, nil
 }
 
 func (a *AppMutex) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
@@ -122,9 +547,36 @@ func (a *AppMutex) CompareCommits(baseSha string, headSha string) (*GithubCommit
 	return &compareCommit, nil
 }
 
+// CompareCommitsFull pages through the compare endpoint, which otherwise
+// stops at 250 commits. With pagination the first page lists the files of
+// the whole comparison.
+func (a *AppMutex) CompareCommitsFull(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	var full *GithubCommitCompare
+	for page := 1; ; page++ {
+		compareCommit := GithubCommitCompare{}
+		resp, err := a.doRequest(a.ApiCommitComparePage(baseSha, headSha, page))
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&compareCommit)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if full == nil {
+			full = &compareCommit
+		} else {
+			full.Commits = append(full.Commits, compareCommit.Commits...)
+		}
+		if len(compareCommit.Commits) == 0 || len(full.Commits) >= full.TotalCommits {
+			return full, nil
+		}
+	}
+}
+
 func MakeAppWithDefaults() AppMutex {
 	return AppMutex{
 		BaseBranchMap: make(map[string]string),
-		Client:        &http.Client{},
+		Client:        NewInstrumentedClient(AppMetrics),
 	}
 }
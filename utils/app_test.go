@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRetrieveErrors checks that a failing Github is reported as an error,
+// which the serve mode logs before keeping its previous reports, instead of
+// exiting
+func TestRetrieveErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first page of pull requests succeeds, the second one fails
+		if r.URL.Path == "/repos/octo/repo/pulls" && r.URL.Query().Get("page") == "1" {
+			json.NewEncoder(w).Encode(PullRequestList{{Number: 1}})
+			return
+		}
+		http.Error(w, "unavailable", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	app := newGithubApp(server)
+
+	if _, err := app.RetrievePullRequestsWithPagination(0); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("RetrievePullRequestsWithPagination error %v, want the 502 of the second page", err)
+	}
+	if _, err := app.RetrieveBranchesWithPagination(0); err == nil {
+		t.Error("RetrieveBranchesWithPagination did not fail")
+	}
+	if _, err := app.GetLastCommit("master"); err == nil {
+		t.Error("GetLastCommit did not fail")
+	}
+	if _, found := app.cachedLastCommit("master"); found {
+		t.Error("the failed lookup of master was cached")
+	}
+}
+
+// TestReportSourceBaseTips checks that the REST source compares the pull
+// requests with the current tip of their base, as the GraphQL source does,
+// rather than with the base commit recorded on the pull request
+func TestReportSourceBaseTips(t *testing.T) {
+	branchCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/octo/repo/pulls":
+			pullRequests := PullRequestList{}
+			if r.URL.Query().Get("page") == "1" {
+				for number := 1; number <= 2; number++ {
+					pr := GithubPullRequest{Number: number}
+					pr.Base.Ref = "master"
+					pr.Base.Sha = "recorded-base"
+					pullRequests = append(pullRequests, pr)
+				}
+			}
+			json.NewEncoder(w).Encode(pullRequests)
+		case "/repos/octo/repo/branches/master":
+			branchCalls++
+			branch := GithubBranch{Name: "master"}
+			branch.Commit.Sha = "tip"
+			json.NewEncoder(w).Encode(branch)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	app := newGithubApp(server)
+	app.Config.Provider = "github"
+
+	pullRequests, _, err := ReportSource(app, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pr := range pullRequests {
+		if pr.Base.Sha != "tip" {
+			t.Errorf("#%d compared with %s, want the tip of master", pr.Number, pr.Base.Sha)
+		}
+	}
+	if branchCalls != 1 {
+		t.Errorf("%d branch calls, want one per base branch", branchCalls)
+	}
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BackportEntry is a merged pull request labelled for a backport to a
+// release branch
+type BackportEntry struct {
+	Number         int       `json:"number"`
+	Title          string    `json:"title"`
+	Author         string    `json:"author"`
+	HTMLURL        string    `json:"html_url"`
+	MergedAt       time.Time `json:"merged_at"`
+	MergeCommitSha string    `json:"merge_commit_sha"`
+	Label          string    `json:"label"`
+	TargetBranch   string    `json:"target_branch"`
+	// Status is "backported", "missing" or "no branch" when the target
+	// branch does not exist, FoundSha being the commit of the target branch
+	// which contains the change
+	Status   string `json:"status"`
+	FoundSha string `json:"found_sha,omitempty"`
+}
+
+type BackportReport struct {
+	Repo        string          `json:"repo"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Since       time.Time       `json:"since"`
+	Entries     []BackportEntry `json:"entries"`
+}
+
+// Missing keeps the entries not found in their target branch, including the
+// ones whose branch does not exist
+func (r BackportReport) Missing() []BackportEntry {
+	missing := []BackportEntry{}
+	for _, e := range r.Entries {
+		if e.Status != "backported" {
+			missing = append(missing, e)
+		}
+	}
+	return missing
+}
+
+// backportTarget maps a label such as "backport/1.4" to its release branch
+// through the template, "*" being replaced by the version
+func backportTarget(labelPrefix string, branchTemplate string, label string) (string, bool) {
+	if !strings.HasPrefix(label, labelPrefix) || label == labelPrefix {
+		return "", false
+	}
+	return strings.Replace(branchTemplate, "*", strings.TrimPrefix(label, labelPrefix), 1), true
+}
+
+var cherryPickedFrom = regexp.MustCompile(`cherry picked from commit ([0-9a-f]{7,40})`)
+
+// cherryPickedShas lists the commits named by the trailers git cherry-pick -x
+// appends to the message
+func cherryPickedShas(message string) []string {
+	shas := []string{}
+	for _, match := range cherryPickedFrom.FindAllStringSubmatch(message, -1) {
+		shas = append(shas, match[1])
+	}
+	return shas
+}
+
+// findBackport looks for the change in the target branch: the merge commit
+// reachable from its tip, or one of the candidates named by the cherry-pick
+// trailer of a commit made since the merge
+func (a *AppMutex) findBackport(entry BackportEntry, candidates []string) (string, error) {
+	if entry.MergeCommitSha != "" {
+		compare, err := a.CompareCommits(entry.MergeCommitSha, entry.TargetBranch)
+		if err != nil {
+			return "", err
+		}
+		if compare.Status == "identical" || compare.Status == "ahead" {
+			return entry.MergeCommitSha, nil
+		}
+	}
+	commits, err := a.RequestBranchCommits(entry.TargetBranch, entry.MergedAt)
+	if err != nil {
+		return "", err
+	}
+	for _, commit := range commits {
+		for _, picked := range cherryPickedShas(commit.Commit.Message) {
+			for _, sha := range candidates {
+				if strings.HasPrefix(sha, picked) {
+					return commit.Sha, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// BuildBackportReport checks the pull requests merged in the last days which
+// carry a label starting with BACKPORT_LABEL_PREFIX
+func (a *AppMutex) BuildBackportReport(days int) (*BackportReport, error) {
+	now := time.Now()
+	since := now.Add(-time.Duration(days) * 24 * time.Hour)
+	merged, err := a.RetrieveMergedPullRequests(since)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []BackportEntry{}
+	for _, pr := range merged {
+		for _, label := range pr.Labels {
+			target, found := backportTarget(a.Config.BackportLabelPrefix, a.Config.BackportBranch, label.Name)
+			if !found {
+				continue
+			}
+			entries = append(entries, BackportEntry{
+				Number:         pr.Number,
+				Title:          pr.Title,
+				Author:         pr.User.Login,
+				HTMLURL:        pr.HTMLURL,
+				MergedAt:       pr.MergedAt,
+				MergeCommitSha: pr.MergeCommitSha,
+				Label:          label.Name,
+				TargetBranch:   target,
+			})
+		}
+	}
+
+	branchList, err := a.RetrieveBranchesWithPagination(0)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]bool)
+	for _, b := range branchList {
+		branches[b.Name] = true
+	}
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i := range entries {
+		entry := &entries[i]
+		if !branches[entry.TargetBranch] {
+			entry.Status = "no branch"
+			continue
+		}
+		eg.Go(func() error {
+			commits, err := a.RequestPullRequestCommits(entry.Number)
+			if err != nil {
+				return err
+			}
+			candidates := []string{entry.MergeCommitSha}
+			for _, commit := range commits {
+				candidates = append(candidates, commit.Sha)
+			}
+			found, err := a.findBackport(*entry, candidates)
+			if err != nil {
+				return err
+			}
+			entry.Status = "missing"
+			if found != "" {
+				entry.Status, entry.FoundSha = "backported", found
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return &BackportReport{Repo: a.Config.Repo(), GeneratedAt: now, Since: since, Entries: entries}, nil
+}
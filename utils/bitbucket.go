@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://docs.atlassian.com/bitbucket-server/rest/latest/bitbucket-rest.html
+type BitbucketPage struct {
+	Size          int  `json:"size"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+type BitbucketRef struct {
+	ID           string `json:"id"`
+	DisplayID    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+	Repository   struct {
+		ID int `json:"id"`
+	} `json:"repository"`
+}
+
+type BitbucketPullRequest struct {
+	ID          int          `json:"id"`
+	Title       string       `json:"title"`
+	State       string       `json:"state"`
+	Draft       bool         `json:"draft"`
+	CreatedDate int64        `json:"createdDate"`
+	FromRef     BitbucketRef `json:"fromRef"`
+	ToRef       BitbucketRef `json:"toRef"`
+	Author      struct {
+		User struct {
+			Name         string `json:"name"`
+			EmailAddress string `json:"emailAddress"`
+		} `json:"user"`
+	} `json:"author"`
+	Links struct {
+		Self []struct {
+			Href string `json:"href"`
+		} `json:"self"`
+	} `json:"links"`
+}
+
+func (p BitbucketPullRequest) ToPullRequest() GithubPullRequest {
+	pr := GithubPullRequest{
+		ID:        p.ID,
+		Number:    p.ID,
+		Title:     p.Title,
+		State:     strings.ToLower(p.State),
+		CreatedAt: time.Unix(0, p.CreatedDate*int64(time.Millisecond)),
+		Draft:     p.Draft,
+		User:      GithubUser{Login: p.Author.User.Name},
+	}
+	if len(p.Links.Self) > 0 {
+		pr.HTMLURL = p.Links.Self[0].Href
+	}
+	pr.Head.Ref = p.FromRef.DisplayID
+	pr.Head.Sha = p.FromRef.LatestCommit
+	pr.Head.Repo.ID = p.FromRef.Repository.ID
+	pr.Base.Ref = p.ToRef.DisplayID
+	pr.Base.Sha = p.ToRef.LatestCommit
+	pr.Base.Repo.ID = p.ToRef.Repository.ID
+	return pr
+}
+
+// BitbucketProvider speaks the Bitbucket Server (Data Center) REST API 1.0,
+// RepoAuthor being the project key and RepoName the repository slug
+type BitbucketProvider struct {
+	forgeClient
+	Config *Config
+}
+
+func NewBitbucketProvider(config *Config, client *http.Client) *BitbucketProvider {
+	return &BitbucketProvider{
+		forgeClient: forgeClient{Client: client, authHeader: "Authorization", authValue: "Bearer " + config.BitbucketToken},
+		Config:      config,
+	}
+}
+
+func (b *BitbucketProvider) apiURL(format string, args ...interface{}) string {
+	return strings.TrimSuffix(b.Config.ProviderURL, "/") + "/rest/api/1.0/projects/" + b.Config.RepoAuthor + "/repos/" + b.Config.RepoName + fmt.Sprintf(format, args...)
+}
+
+// ListChangeRequests follows nextPageStart until isLastPage
+func (b *BitbucketProvider) ListChangeRequests() (PullRequestList, error) {
+	pullRequests := PullRequestList{}
+	for start := 0; ; {
+		page := struct {
+			BitbucketPage
+			Values []BitbucketPullRequest `json:"values"`
+		}{}
+		if _, err := b.getJSON(b.apiURL("/pull-requests?state=OPEN&limit=100&start=%d", start), &page); err != nil {
+			return nil, errors.Wrap(err, "listChangeRequests")
+		}
+		for _, pr := range page.Values {
+			pullRequests = append(pullRequests, pr.ToPullRequest())
+		}
+		if page.IsLastPage || len(page.Values) == 0 {
+			return pullRequests, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (b *BitbucketProvider) BranchHead(branch string) (string, error) {
+	page := struct {
+		Values []BitbucketRef `json:"values"`
+	}{}
+	if _, err := b.getJSON(b.apiURL("/branches?filterText=%s", url.QueryEscape(branch)), &page); err != nil {
+		return "", errors.Wrap(err, "branchHead")
+	}
+	for _, ref := range page.Values {
+		if ref.DisplayID == branch {
+			return ref.LatestCommit, nil
+		}
+	}
+	return "", errors.New("branchHead: branch not found " + branch)
+}
+
+func (b *BitbucketProvider) PullRequestHead(pr GithubPullRequest) (string, error) {
+	return pr.Head.Sha, nil
+}
+
+// countCommits pages through the commits reachable from from and not from to
+func (b *BitbucketProvider) countCommits(from string, to string) (int, error) {
+	count := 0
+	for start := 0; ; {
+		page := BitbucketPage{}
+		query := fmt.Sprintf("/compare/commits?from=%s&to=%s&limit=1000&start=%d", url.QueryEscape(from), url.QueryEscape(to), start)
+		if _, err := b.getJSON(b.apiURL("%s", query), &page); err != nil {
+			return 0, errors.Wrap(err, "compareCommits")
+		}
+		count += page.Size
+		if page.IsLastPage || page.Size == 0 {
+			return count, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (b *BitbucketProvider) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	aheadBy, err := b.countCommits(headSha, baseSha)
+	if err != nil {
+		return nil, err
+	}
+	behindBy, err := b.countCommits(baseSha, headSha)
+	if err != nil {
+		return nil, err
+	}
+	return &GithubCommitCompare{
+		Status:       compareStatus(aheadBy, behindBy),
+		AheadBy:      aheadBy,
+		BehindBy:     behindBy,
+		TotalCommits: aheadBy,
+	}, nil
+}
+
+// AddLabels is not supported, Bitbucket Server pull requests have no labels
+func (b *BitbucketProvider) AddLabels(number int, labels []string) error {
+	return errors.New("addLabels: pull request labels are not supported by Bitbucket Server")
+}
+
+func (b *BitbucketProvider) AddComment(number int, comment string) error {
+	return b.write("POST", b.apiURL("/pull-requests/%d/comments", number), map[string]string{"text": comment})
+}
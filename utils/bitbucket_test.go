@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func bitbucketPullRequest(id int, from string, repoID int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          id,
+		"title":       "PR " + strconv.Itoa(id),
+		"state":       "OPEN",
+		"createdDate": 1551434400000,
+		"fromRef":     map[string]interface{}{"id": "refs/heads/" + from, "displayId": from, "latestCommit": from + "-sha", "repository": map[string]int{"id": repoID}},
+		"toRef":       map[string]interface{}{"id": "refs/heads/master", "displayId": "master", "latestCommit": "master-sha", "repository": map[string]int{"id": 1}},
+		"author":      map[string]interface{}{"user": map[string]string{"name": "alice", "emailAddress": "alice@example.com"}},
+		"links":       map[string]interface{}{"self": []map[string]string{{"href": "https://bitbucket.example.com/projects/GROUP/repos/project/pull-requests/" + strconv.Itoa(id)}}},
+	}
+}
+
+func TestBitbucketProviderReport(t *testing.T) {
+	graph := commitGraph{
+		{"feature-sha", "master-sha"}: 3, {"master-sha", "feature-sha"}: 120,
+		{"fork-sha", "master-sha"}: 1, {"master-sha", "fork-sha"}: 0,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query := r.URL.Query()
+		start, _ := strconv.Atoi(query.Get("start"))
+		switch r.URL.Path {
+		case "/rest/api/1.0/projects/group/repos/project/pull-requests":
+			if start == 0 {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"size": 1, "isLastPage": false, "nextPageStart": 1,
+					"values": []interface{}{bitbucketPullRequest(1, "feature", 1)},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"size": 1, "isLastPage": true,
+				"values": []interface{}{bitbucketPullRequest(2, "fork", 9)},
+			})
+		case "/rest/api/1.0/projects/group/repos/project/compare/commits":
+			// pages of at most 100 commits
+			size := graph.count(t, query.Get("from"), query.Get("to")) - start
+			isLastPage := size <= 100
+			if !isLastPage {
+				size = 100
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"size": size, "isLastPage": isLastPage, "nextPageStart": start + size})
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	app := newForgeApp("bitbucket", server.URL)
+	provider, err := NewProvider(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullRequests, err := provider.ListChangeRequests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pullRequests) != 2 {
+		t.Fatalf("%d pull requests, want 2", len(pullRequests))
+	}
+	compares := compareAll(t, provider, pullRequests)
+	if feature := compares[1]; feature.AheadBy != 3 || feature.BehindBy != 120 || feature.Status != "diverged" {
+		t.Errorf("#1: %d ahead, %d behind, %s, want 3 ahead, 120 behind, diverged", feature.AheadBy, feature.BehindBy, feature.Status)
+	}
+	if fork := compares[2]; fork.AheadBy != 1 || fork.BehindBy != 0 {
+		t.Errorf("#2: %d ahead, %d behind, want 1 ahead, 0 behind", fork.AheadBy, fork.BehindBy)
+	}
+	if pullRequests[0].FromFork() || !pullRequests[1].FromFork() {
+		t.Errorf("#2 alone should come from a fork")
+	}
+	if pr := pullRequests[0]; pr.User.Login != "alice" || pr.CreatedAt.Year() != 2019 || pr.HTMLURL == "" {
+		t.Errorf("#1 mapped as %q by %s on %s", pr.Title, pr.User.Login, pr.CreatedAt)
+	}
+}
+
+func TestBitbucketProviderLabels(t *testing.T) {
+	provider := NewBitbucketProvider(newForgeApp("bitbucket", "http://bitbucket.invalid").Config, http.DefaultClient)
+	if err := provider.AddLabels(1, []string{"outdated"}); err == nil {
+		t.Error("AddLabels did not fail, Bitbucket Server has no labels")
+	}
+}
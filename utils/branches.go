@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// BranchRow tells how stale a branch is compared to the default branch
+type BranchRow struct {
+	Repo         string    `json:"repo"`
+	Branch       string    `json:"branch"`
+	Sha          string    `json:"sha"`
+	Protected    bool      `json:"protected"`
+	HasOpenPR    bool      `json:"has_open_pr"`
+	LastCommitAt time.Time `json:"last_commit_at"`
+	AgeDays      int       `json:"age_days"`
+	Status       string    `json:"status"`
+	AheadBy      int       `json:"ahead_by"`
+	BehindBy     int       `json:"behind_by"`
+}
+
+type BranchReport struct {
+	Repo          string      `json:"repo"`
+	DefaultBranch string      `json:"default_branch"`
+	GeneratedAt   time.Time   `json:"generated_at"`
+	Rows          []BranchRow `json:"rows"`
+}
+
+// BuildBranchReport compares every branch with the default branch, which
+// costs a branch and a compare call per branch
+func (a *AppMutex) BuildBranchReport(branches BranchList, pullRequests PullRequestList) (*BranchReport, error) {
+	defaultBranch, err := a.GetDefaultBranch()
+	if err != nil {
+		return nil, err
+	}
+	openHeads := make(map[string]bool)
+	for _, pr := range pullRequests {
+		openHeads[pr.Head.Ref] = true
+	}
+
+	now := time.Now()
+	rows := make([]BranchRow, len(branches))
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i, b := range branches {
+		i, b := i, b
+		eg.Go(func() error {
+			branch, err := a.RequestBranch(b.Name)
+			if err != nil {
+				return err
+			}
+			compare, err := a.CompareCommits(defaultBranch, branch.Commit.Sha)
+			if err != nil {
+				return err
+			}
+			lastCommitAt, _ := time.Parse(time.RFC3339, branch.Commit.Commit.Committer.Date)
+			rows[i] = BranchRow{
+				Repo:         a.Config.Repo(),
+				Branch:       b.Name,
+				Sha:          branch.Commit.Sha,
+				Protected:    b.Protected,
+				HasOpenPR:    openHeads[b.Name],
+				LastCommitAt: lastCommitAt,
+				AgeDays:      int(now.Sub(lastCommitAt).Hours() / 24),
+				Status:       compare.Status,
+				AheadBy:      compare.AheadBy,
+				BehindBy:     compare.BehindBy,
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return &BranchReport{Repo: a.Config.Repo(), DefaultBranch: defaultBranch, GeneratedAt: now, Rows: rows}, nil
+}
+
+// GenerateBranchReport is only supported on Github, the other providers do
+// not list branches
+func GenerateBranchReport(config *Config) (*BranchReport, error) {
+	if config.Provider != "github" {
+		return nil, errors.New("branch report is not supported by provider " + config.Provider)
+	}
+	app := MakeAppWithDefaults()
+	app.Config = config
+	branches, err := app.RetrieveBranchesWithPagination(0)
+	if err != nil {
+		return nil, err
+	}
+	pullRequests, err := app.RetrievePullRequestsWithPagination(0)
+	if err != nil {
+		return nil, err
+	}
+	return app.BuildBranchReport(branches, pullRequests)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import "golang.org/x/sync/errgroup"
+
+// ciStatus merges the commit statuses and the check runs of a commit: any
+// failure wins over anything pending, which wins over success
+func ciStatus(combined *GithubCombinedStatus, checkRuns []GithubCheckRun) string {
+	states := []string{}
+	if combined.TotalCount > 0 {
+		switch combined.State {
+		case "failure", "error":
+			states = append(states, "failure")
+		default:
+			states = append(states, combined.State)
+		}
+	}
+	for _, run := range checkRuns {
+		if run.Status != "completed" {
+			states = append(states, "pending")
+			continue
+		}
+		switch run.Conclusion {
+		case "success", "neutral", "skipped":
+			states = append(states, "success")
+		default:
+			states = append(states, "failure")
+		}
+	}
+
+	status := "none"
+	for _, state := range states {
+		switch {
+		case state == "failure":
+			return "failure"
+		case state == "pending":
+			status = "pending"
+		case status == "none":
+			status = "success"
+		}
+	}
+	return status
+}
+
+func (a *AppMutex) CIStatus(sha string) (string, error) {
+	combined, err := a.RequestCombinedStatus(sha)
+	if err != nil {
+		return "", err
+	}
+	checkRuns, err := a.RequestCheckRuns(sha)
+	if err != nil {
+		return "", err
+	}
+	return ciStatus(combined, checkRuns), nil
+}
+
+// AddCIStatus costs at least two calls per pull request, the combined status
+// and the check runs of its head
+func (a *AppMutex) AddCIStatus(report *Report) error {
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		eg.Go(func() error {
+			status, err := a.CIStatus(row.HeadSha)
+			if err != nil {
+				return err
+			}
+			row.CIStatus = status
+			return nil
+		})
+	}
+	report.CIFetched = true
+	return eg.Wait()
+}
+
+// GreenButBehind tells the pull requests whose passing build ran against an
+// old base
+func (row ReportRow) GreenButBehind() bool {
+	return row.CIStatus == "success" && row.BehindBy > 0
+}
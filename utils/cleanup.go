@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	CleanupReasonMerged    = "merged"
+	CleanupReasonAbandoned = "abandoned"
+)
+
+// BranchCleanupEntry is written to the cleanup log for every deleted branch,
+// so that it can be restored with `git push origin <sha>:refs/heads/<branch>`
+type BranchCleanupEntry struct {
+	Branch       string    `json:"branch"`
+	Sha          string    `json:"sha"`
+	Reason       string    `json:"reason"`
+	LastCommitAt time.Time `json:"last_commit_at"`
+	DeletedAt    time.Time `json:"deleted_at"`
+}
+
+type CleanupPolicy struct {
+	DefaultBranch string
+	MaxAge        time.Duration
+	Allowlist     []string
+	OpenPRHeads   map[string]bool
+	Now           time.Time
+}
+
+func NewCleanupPolicy(config *Config, defaultBranch string, pullRequests PullRequestList) CleanupPolicy {
+	heads := make(map[string]bool)
+	for _, pr := range pullRequests {
+		heads[pr.Head.Ref] = true
+	}
+	return CleanupPolicy{
+		DefaultBranch: defaultBranch,
+		MaxAge:        time.Duration(config.CleanupMaxAgeDays) * 24 * time.Hour,
+		Allowlist:     config.CleanupAllowlist,
+		OpenPRHeads:   heads,
+		Now:           time.Now(),
+	}
+}
+
+// Keep tells whether a branch must never be considered for deletion
+func (p CleanupPolicy) Keep(branch GithubBranch) bool {
+	if branch.Name == p.DefaultBranch || branch.Protected || p.OpenPRHeads[branch.Name] {
+		return true
+	}
+	for _, pattern := range p.Allowlist {
+		if matched, _ := path.Match(pattern, branch.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CleanupPolicy) Abandoned(lastCommitAt time.Time) bool {
+	return p.MaxAge > 0 && !lastCommitAt.IsZero() && p.Now.Sub(lastCommitAt) > p.MaxAge
+}
+
+func (a *AppMutex) FindCleanupCandidates(policy CleanupPolicy, branches BranchList) ([]BranchCleanupEntry, error) {
+	candidates := []BranchCleanupEntry{}
+	for _, b := range branches {
+		if policy.Keep(b) {
+			continue
+		}
+		branch, err := a.RequestBranch(b.Name)
+		if err != nil {
+			return nil, err
+		}
+		lastCommitAt, _ := time.Parse(time.RFC3339, branch.Commit.Commit.Committer.Date)
+		entry := BranchCleanupEntry{
+			Branch:       branch.Name,
+			Sha:          branch.Commit.Sha,
+			LastCommitAt: lastCommitAt,
+		}
+
+		compare, err := a.CompareCommits(policy.DefaultBranch, branch.Commit.Sha)
+		if err != nil {
+			return nil, err
+		}
+		// Only trust an explicit status: an error payload would decode as 0
+		// ahead. A branch identical to the default branch may have just been
+		// created for new work, it only goes once abandoned.
+		if compare.Status == "behind" {
+			entry.Reason = CleanupReasonMerged
+		} else if policy.Abandoned(lastCommitAt) {
+			entry.Reason = CleanupReasonAbandoned
+		} else {
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+	return candidates, nil
+}
+
+// AppendCleanupLog writes one JSON object per line, so that successive runs
+// keep extending the same file
+func AppendCleanupLog(logPath string, entry BranchCleanupEntry) error {
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(entry)
+}
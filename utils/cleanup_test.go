@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forwardTransport is the default transport before any test replaces it
+var forwardTransport = http.DefaultTransport
+
+// githubTransport sends the requests meant for api.github.com to a fake
+// server, keeping their escaped path
+type githubTransport struct {
+	server *httptest.Server
+}
+
+func (t githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	return forwardTransport.RoundTrip(req)
+}
+
+// newGithubApp targets the repository octo/repo on a fake Github
+func newGithubApp(server *httptest.Server) *AppMutex {
+	app := MakeAppWithDefaults()
+	app.Client = &http.Client{Transport: githubTransport{server}}
+	app.Config = &Config{OauthToken: "secret", RepoAuthor: "octo", RepoName: "repo"}
+	return &app
+}
+
+func TestCleanupPolicyKeep(t *testing.T) {
+	policy := CleanupPolicy{
+		DefaultBranch: "master",
+		Allowlist:     []string{"release/*", "keep-*"},
+		OpenPRHeads:   map[string]bool{"feature": true},
+	}
+	for _, test := range []struct {
+		branch GithubBranch
+		keep   bool
+	}{
+		{GithubBranch{Name: "master"}, true},
+		{GithubBranch{Name: "stable", Protected: true}, true},
+		{GithubBranch{Name: "feature"}, true},
+		{GithubBranch{Name: "release/1.0"}, true},
+		{GithubBranch{Name: "keep-me"}, true},
+		{GithubBranch{Name: "release/1.0/hotfix"}, false},
+		{GithubBranch{Name: "feature#12"}, false},
+		{GithubBranch{Name: "old-work"}, false},
+	} {
+		if keep := policy.Keep(test.branch); keep != test.keep {
+			t.Errorf("Keep(%+v) = %t, want %t", test.branch, keep, test.keep)
+		}
+	}
+}
+
+func TestCleanupPolicyAbandoned(t *testing.T) {
+	now := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		maxAge       time.Duration
+		lastCommitAt time.Time
+		abandoned    bool
+	}{
+		{0, now.AddDate(-1, 0, 0), false},
+		{24 * time.Hour, time.Time{}, false},
+		{24 * time.Hour, now.Add(-time.Hour), false},
+		{24 * time.Hour, now.AddDate(0, 0, -2), true},
+	} {
+		policy := CleanupPolicy{MaxAge: test.maxAge, Now: now}
+		if abandoned := policy.Abandoned(test.lastCommitAt); abandoned != test.abandoned {
+			t.Errorf("Abandoned(%s) with a max age of %s = %t, want %t", test.lastCommitAt, test.maxAge, abandoned, test.abandoned)
+		}
+	}
+}
+
+func TestFindCleanupCandidates(t *testing.T) {
+	now := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	// the status of every branch compared with master, and its last commit
+	branches := map[string]struct {
+		status       string
+		lastCommitAt time.Time
+	}{
+		"merged":        {"behind", now},
+		"fix#12":        {"behind", now},
+		"fix":           {"ahead", now},
+		"new-work":      {"identical", now},
+		"old-copy":      {"identical", now.AddDate(0, -3, 0)},
+		"old-work":      {"diverged", now.AddDate(0, -3, 0)},
+		"recent-work":   {"diverged", now},
+		"release/1.0":   {"behind", now},
+		"open-pr":       {"behind", now},
+		"protected-old": {"behind", now.AddDate(0, -3, 0)},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		path := r.URL.EscapedPath()
+		switch {
+		case strings.HasPrefix(path, "/repos/octo/repo/branches/"):
+			name, _ := url.PathUnescape(strings.TrimPrefix(path, "/repos/octo/repo/branches/"))
+			b, found := branches[name]
+			if !found {
+				t.Errorf("unexpected branch %s", path)
+				http.NotFound(w, r)
+				return
+			}
+			branch := GithubBranch{Name: name}
+			branch.Commit.Sha = name + "-sha"
+			branch.Commit.Commit.Committer.Date = b.lastCommitAt.Format(time.RFC3339)
+			json.NewEncoder(w).Encode(branch)
+		case strings.HasPrefix(path, "/repos/octo/repo/compare/master..."):
+			name, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "/repos/octo/repo/compare/master..."), "-sha"))
+			json.NewEncoder(w).Encode(GithubCommitCompare{Status: branches[name].status})
+		default:
+			t.Errorf("unexpected request %s", path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	policy := CleanupPolicy{
+		DefaultBranch: "master",
+		MaxAge:        30 * 24 * time.Hour,
+		Allowlist:     []string{"release/*"},
+		OpenPRHeads:   map[string]bool{"open-pr": true},
+		Now:           now,
+	}
+	list := BranchList{{Name: "master"}, {Name: "protected-old", Protected: true}}
+	for _, name := range []string{"merged", "fix#12", "fix", "new-work", "old-copy", "old-work", "recent-work", "release/1.0", "open-pr"} {
+		list = append(list, GithubBranch{Name: name})
+	}
+	candidates, err := newGithubApp(server).FindCleanupCandidates(policy, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, c := range candidates {
+		got = append(got, c.Branch+" "+c.Sha+" "+c.Reason)
+	}
+	want := []string{"merged merged-sha merged", "fix#12 fix#12-sha merged", "old-copy old-copy-sha abandoned", "old-work old-work-sha abandoned"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("candidates %q, want %q", got, want)
+	}
+}
+
+func TestDeleteBranchEscapesTheName(t *testing.T) {
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.Method+" "+r.URL.EscapedPath())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	app := newGithubApp(server)
+	for _, branch := range []string{"feature/fix#12", "100%zz", "a b?c"} {
+		if err := app.DeleteBranch(branch); err != nil {
+			t.Errorf("DeleteBranch(%q): %v", branch, err)
+		}
+	}
+	want := []string{
+		"DELETE /repos/octo/repo/git/refs/heads/feature/fix%2312",
+		"DELETE /repos/octo/repo/git/refs/heads/100%25zz",
+		"DELETE /repos/octo/repo/git/refs/heads/a%20b%3Fc",
+	}
+	if strings.Join(deleted, ", ") != strings.Join(want, ", ") {
+		t.Errorf("requests %q, want %q", deleted, want)
+	}
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// CodeownersLocations are where Github looks for the file, in this order
+var CodeownersLocations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type CodeownersRule struct {
+	Pattern string
+	// Owners are "@user", "@org/team" or email addresses, none when the
+	// pattern removes the owners of its paths
+	Owners []string
+	regexp *regexp.Regexp
+}
+
+type Codeowners []CodeownersRule
+
+// codeownersFields splits a line on the spaces which are not escaped,
+// dropping the trailing comment
+func codeownersFields(line string) []string {
+	fields := []string{}
+	field := strings.Builder{}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			field.WriteByte(c)
+			field.WriteByte(line[i+1])
+			i++
+		case c == '#' && field.Len() == 0:
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// compileCodeownersPattern follows the gitignore rules used by Github:
+// a pattern with a leading or inner slash is relative to the root and
+// matches anywhere otherwise, a trailing slash only matches directories,
+// "*" and "?" stop at slashes and "**" does not, and matching a directory
+// matches everything below it, except for the patterns ending with "/*".
+// Negations and character ranges are not supported by Github.
+func compileCodeownersPattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	dirOnly := strings.HasSuffix(pattern, "/")
+	body := strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/")
+	if body == "" {
+		return nil, errors.New("empty pattern " + pattern)
+	}
+
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(.*/)?")
+	}
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(body[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case body[i] == '*':
+			expr.WriteString("[^/]*")
+		case body[i] == '?':
+			expr.WriteString("[^/]")
+		case body[i] == '\\' && i+1 < len(body):
+			i++
+			expr.WriteString(regexp.QuoteMeta(body[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(body[i : i+1]))
+		}
+	}
+	switch {
+	case dirOnly:
+		expr.WriteString("/.*$")
+	case strings.HasSuffix(body, "/*"):
+		expr.WriteString("$")
+	default:
+		expr.WriteString("(/.*)?$")
+	}
+	return regexp.Compile(expr.String())
+}
+
+func ParseCodeowners(r io.Reader) (Codeowners, error) {
+	codeowners := Codeowners{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := codeownersFields(strings.TrimSpace(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		re, err := compileCodeownersPattern(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parseCodeowners line %d", n)
+		}
+		codeowners = append(codeowners, CodeownersRule{Pattern: fields[0], Owners: fields[1:], regexp: re})
+	}
+	return codeowners, scanner.Err()
+}
+
+// Owners applies the last rule matching the path, as Github does
+func (c Codeowners) Owners(path string) []string {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].regexp.MatchString(path) {
+			return c[i].Owners
+		}
+	}
+	return nil
+}
+
+// OwnersOf merges the owners of every path
+func (c Codeowners) OwnersOf(paths []string) []string {
+	seen := make(map[string]bool)
+	owners := []string{}
+	for _, path := range paths {
+		for _, owner := range c.Owners(path) {
+			if !seen[owner] {
+				seen[owner] = true
+				owners = append(owners, owner)
+			}
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// LoadCodeowners reads the local file when a path is given, and otherwise
+// the first of the CodeownersLocations found on the default branch
+func (a *AppMutex) LoadCodeowners(localPath string) (Codeowners, error) {
+	if localPath != "" {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseCodeowners(f)
+	}
+	for _, location := range CodeownersLocations {
+		content, err := a.RequestContent(location)
+		if err != nil {
+			return nil, err
+		}
+		if content == nil {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(content.Content)
+		if err != nil {
+			return nil, errors.Wrap(err, "loadCodeowners "+location)
+		}
+		return ParseCodeowners(strings.NewReader(string(data)))
+	}
+	return nil, errors.New("loadCodeowners: no CODEOWNERS file in " + a.Config.Repo())
+}
+
+// AddOwners costs one call per pull request, unless the changed files were
+// already listed by AddCompareDetails
+func (a *AppMutex) AddOwners(report *Report, codeowners Codeowners) error {
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		eg.Go(func() error {
+			files := row.ChangedFiles
+			if files == nil {
+				var err error
+				if files, err = a.RequestPullRequestFiles(row.Number); err != nil {
+					return err
+				}
+			}
+			paths := []string{}
+			for path := range changedPaths(files) {
+				paths = append(paths, path)
+			}
+			row.Owners = codeowners.OwnersOf(paths)
+			return nil
+		})
+	}
+	report.OwnersFetched = true
+	return eg.Wait()
+}
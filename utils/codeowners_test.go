@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileCodeownersPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		// without a slash a pattern matches at any depth
+		{"*.js", []string{"app.js", "web/app.js"}, []string{"app.jsx", "app.ts"}},
+		{"docs", []string{"docs", "docs/index.md", "web/docs/index.md"}, []string{"documents/index.md"}},
+		// a leading or inner slash anchors it to the root
+		{"/docs", []string{"docs/index.md"}, []string{"web/docs/index.md"}},
+		{"web/docs", []string{"web/docs/index.md"}, []string{"api/web/docs/index.md"}},
+		// a trailing slash only matches the directories
+		{"build/", []string{"build/app.js", "web/build/app.js"}, []string{"build", "builds/app.js"}},
+		// "/*" stops at the first level when "/**" does not
+		{"docs/*", []string{"docs/index.md"}, []string{"docs/api/index.md", "docs"}},
+		{"docs/**", []string{"docs/index.md", "docs/api/index.md"}, []string{"web/docs/index.md"}},
+		{"**/logs", []string{"logs/today.log", "var/logs/today.log", "var/lib/logs"}, []string{"catalogs/today.log"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b/c"}, []string{"a/xb"}},
+		{"?.go", []string{"a.go", "cmd/a.go"}, []string{"ab.go"}},
+		{"my\\ file.txt", []string{"my file.txt", "docs/my file.txt"}, []string{"my\\ file.txt", "myfile.txt"}},
+	} {
+		re, err := compileCodeownersPattern(test.pattern)
+		if err != nil {
+			t.Errorf("compileCodeownersPattern(%q): %v", test.pattern, err)
+			continue
+		}
+		for _, path := range test.matches {
+			if !re.MatchString(path) {
+				t.Errorf("%q does not match %q", test.pattern, path)
+			}
+		}
+		for _, path := range test.misses {
+			if re.MatchString(path) {
+				t.Errorf("%q matches %q", test.pattern, path)
+			}
+		}
+	}
+	if _, err := compileCodeownersPattern("/"); err == nil {
+		t.Error("the empty pattern / compiled")
+	}
+}
+
+func TestCodeownersOwners(t *testing.T) {
+	codeowners, err := ParseCodeowners(strings.NewReader(`# the default owners
+*                 @octo/core
+*.js              @octo/web   web@example.com # inline comment
+/docs/            @writer
+/docs/generated/
+my\ notes.txt     @octo/notes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codeowners) != 5 {
+		t.Fatalf("%d rules, want 5", len(codeowners))
+	}
+	for _, test := range []struct {
+		path   string
+		owners string
+	}{
+		{"main.go", "@octo/core"},
+		// the last matching rule wins, they are not merged
+		{"web/app.js", "@octo/web web@example.com"},
+		{"docs/app.js", "@writer"},
+		// a rule without owners removes them
+		{"docs/generated/api.md", ""},
+		{"my notes.txt", "@octo/notes"},
+	} {
+		if owners := strings.Join(codeowners.Owners(test.path), " "); owners != test.owners {
+			t.Errorf("Owners(%q) = %q, want %q", test.path, owners, test.owners)
+		}
+	}
+	if owners := codeowners.OwnersOf([]string{"web/app.js", "main.go", "lib.js"}); strings.Join(owners, " ") != "@octo/core @octo/web web@example.com" {
+		t.Errorf("OwnersOf = %q", owners)
+	}
+}
@@ -1,12 +1,111 @@
 package utils
 
-import "os"
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-// Config should be parsed from a JSON passed a argument later
+	"github.com/pkg/errors"
+)
+
+// Config is read from the environment, then from the JSON file passed with
+// -config and from the command line flags
 type Config struct {
 	OauthToken string `json:"oauth_token"`
 	RepoAuthor string `json:"repo_author"`
 	RepoName   string `json:"repo_name"`
+
+	CleanupMaxAgeDays int      `json:"cleanup_max_age_days"`
+	CleanupAllowlist  []string `json:"cleanup_allowlist"`
+	CleanupDryRun     bool     `json:"cleanup_dry_run"`
+	CleanupLogPath    string   `json:"cleanup_log_path"`
+
+	LocalGitDir    string `json:"local_git_dir"`
+	LocalGitRemote string `json:"local_git_remote"`
+
+	// DataSource is either "rest" or "graphql"
+	DataSource      string `json:"data_source"`
+	GraphqlURL      string `json:"graphql_url"`
+	GraphqlPageSize int    `json:"graphql_page_size"`
+
+	// Provider is one of "github", "gitlab", "gitea" (or "forgejo") and
+	// "bitbucket", ProviderURL points to the forge instance for the
+	// self-hosted ones. Only GitLab has a default, gitlab.com.
+	Provider       string `json:"provider"`
+	ProviderURL    string `json:"provider_url"`
+	GitlabToken    string `json:"gitlab_token"`
+	GiteaToken     string `json:"gitea_token"`
+	BitbucketToken string `json:"bitbucket_token"`
+
+	// Repos lists the "author/name" repositories of the dashboard, the
+	// configured one when empty
+	Repos                []string `json:"repos"`
+	ServeAddr            string   `json:"serve_addr"`
+	ServeIntervalMinutes int      `json:"serve_interval_minutes"`
+	ServeBranches        bool     `json:"serve_branches"`
+	APIToken             string   `json:"api_token"`
+	WebhookSecret        string   `json:"webhook_secret"`
+
+	SlackWebhookURL string `json:"slack_webhook_url"`
+	TeamsWebhookURL string `json:"teams_webhook_url"`
+	NotifyTopN      int    `json:"notify_top_n"`
+	ChatHandlesPath string `json:"chat_handles_path"`
+
+	SMTPHost            string   `json:"smtp_host"`
+	SMTPPort            int      `json:"smtp_port"`
+	SMTPUsername        string   `json:"smtp_username"`
+	SMTPPassword        string   `json:"smtp_password"`
+	SMTPFrom            string   `json:"smtp_from"`
+	SMTPTo              []string `json:"smtp_to"`
+	SMTPRequireStartTLS bool     `json:"smtp_require_starttls"`
+	// EmailAuthors also sends every author the list of their outdated PRs
+	EmailAuthors     bool   `json:"email_authors"`
+	AuthorEmailsPath string `json:"author_emails_path"`
+
+	// HistoryPath is the BoltDB file where every run is recorded, the history
+	// is disabled when empty
+	HistoryPath string `json:"history_path"`
+
+	// LogLevel is one of LogLevels and LogFormat either "text" or "json",
+	// the logs always going to stderr
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+
+	// OutputFormat is one of OutputFormats
+	OutputFormat        string `json:"output_format"`
+	DiffBehindThreshold int    `json:"diff_behind_threshold"`
+
+	PredictConflicts bool `json:"predict_conflicts"`
+	// CompareDetails adds the complete lists of changed files and missing
+	// base commits to the JSON output
+	CompareDetails bool `json:"compare_details"`
+	ReviewStatus   bool `json:"review_status"`
+	CIStatus       bool `json:"ci_status"`
+	// Codeowners routes the notifications to the owners of the changed
+	// files, read from CodeownersPath or from the repository when empty
+	Codeowners     bool   `json:"codeowners"`
+	CodeownersPath string `json:"codeowners_path"`
+	// ReportFilter is one of the ReportFilters, empty for every PR
+	ReportFilter string `json:"report_filter"`
+	// GroupBy is either empty, "milestone" or "stack"
+	GroupBy          string `json:"group_by"`
+	MilestoneDueDays int    `json:"milestone_due_days"`
+
+	// ReleaseBranches are the patterns of the release branches, "*" not
+	// matching slashes
+	ReleaseBranches []string `json:"release_branches"`
+	// BackportBranch is the release branch of the labels starting with
+	// BackportLabelPrefix, "*" being replaced by the rest of the label
+	BackportLabelPrefix string `json:"backport_label_prefix"`
+	BackportBranch      string `json:"backport_branch"`
+	BackportDays        int    `json:"backport_days"`
+
+	// ActionLabels and ActionComment are what the actions add to a pull
+	// request, see ActionComment for the placeholders of the comment
+	ActionLabels  []string `json:"action_labels"`
+	ActionComment string   `json:"action_comment"`
 }
 
 func withDefault(a string, b string) string {
@@ -16,10 +115,176 @@ func withDefault(a string, b string) string {
 	return a
 }
 
+func intWithDefault(a string, b int) int {
+	i, err := strconv.Atoi(a)
+	if err != nil {
+		return b
+	}
+	return i
+}
+
+func boolWithDefault(a string, b bool) bool {
+	v, err := strconv.ParseBool(a)
+	if err != nil {
+		return b
+	}
+	return v
+}
+
+func splitList(a string) []string {
+	xs := []string{}
+	for _, x := range strings.Split(a, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			xs = append(xs, x)
+		}
+	}
+	return xs
+}
+
+func (c Config) Repo() string {
+	return c.RepoAuthor + "/" + c.RepoName
+}
+
+// ForRepo returns a copy of the config targeting another "author/name"
+// repository
+func (c Config) ForRepo(fullName string) Config {
+	parts := strings.SplitN(fullName, "/", 2)
+	if len(parts) == 2 {
+		c.RepoAuthor, c.RepoName = parts[0], parts[1]
+	}
+	return c
+}
+
+// AllRepos returns Repos, defaulting to the configured repository
+func (c Config) AllRepos() []string {
+	if len(c.Repos) == 0 {
+		return []string{c.Repo()}
+	}
+	return c.Repos
+}
+
+// ServeInterval is the time between two refreshes of the dashboard, falling
+// back to the default 15 minutes when ServeIntervalMinutes is below 1
+func (c Config) ServeInterval() time.Duration {
+	if c.ServeIntervalMinutes < 1 {
+		return 15 * time.Minute
+	}
+	return time.Duration(c.ServeIntervalMinutes) * time.Minute
+}
+
+// GraphqlBatchSize clamps GraphqlPageSize to the 1 to 100 nodes a GraphQL
+// connection returns per page
+func (c Config) GraphqlBatchSize() int {
+	if c.GraphqlPageSize < 1 {
+		return 1
+	}
+	if c.GraphqlPageSize > 100 {
+		return 100
+	}
+	return c.GraphqlPageSize
+}
+
+func (c Config) HistoryEnabled() bool {
+	return c.HistoryPath != ""
+}
+
+// Token returns the credential of the configured provider
+func (c Config) Token() string {
+	switch c.Provider {
+	case "gitlab":
+		return c.GitlabToken
+	case "gitea", "forgejo":
+		return c.GiteaToken
+	case "bitbucket":
+		return c.BitbucketToken
+	default:
+		return c.OauthToken
+	}
+}
+
+// LoadConfigFile overrides the config with the fields set in a JSON file,
+// named after the json tags of Config
+func LoadConfigFile(path string, config *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	return errors.Wrap(decoder.Decode(config), "loadConfigFile: "+path)
+}
+
 func NewConfigFromEnv() Config {
 	return Config{
 		OauthToken: os.Getenv("GITHUB_OAUTH_TOKEN"),
 		RepoAuthor: withDefault(os.Getenv("REPO_AUTHOR"), "mberlanda"),
 		RepoName:   withDefault(os.Getenv("REPO_NAME"), "outdated_branches"),
+
+		CleanupMaxAgeDays: intWithDefault(os.Getenv("CLEANUP_MAX_AGE_DAYS"), 90),
+		CleanupAllowlist:  splitList(os.Getenv("CLEANUP_ALLOWLIST")),
+		CleanupDryRun:     boolWithDefault(os.Getenv("CLEANUP_DRY_RUN"), false),
+		CleanupLogPath:    withDefault(os.Getenv("CLEANUP_LOG"), "deleted_branches.json"),
+
+		LocalGitDir:    os.Getenv("LOCAL_GIT_DIR"),
+		LocalGitRemote: withDefault(os.Getenv("LOCAL_GIT_REMOTE"), "origin"),
+
+		DataSource:      withDefault(os.Getenv("DATA_SOURCE"), "rest"),
+		GraphqlURL:      withDefault(os.Getenv("GITHUB_GRAPHQL_URL"), "https://api.github.com/graphql"),
+		GraphqlPageSize: intWithDefault(os.Getenv("GRAPHQL_PAGE_SIZE"), 100),
+
+		Provider:       withDefault(os.Getenv("PROVIDER"), "github"),
+		ProviderURL:    os.Getenv("PROVIDER_URL"),
+		GitlabToken:    os.Getenv("GITLAB_TOKEN"),
+		GiteaToken:     os.Getenv("GITEA_TOKEN"),
+		BitbucketToken: os.Getenv("BITBUCKET_TOKEN"),
+
+		Repos:                splitList(os.Getenv("REPOS")),
+		ServeAddr:            withDefault(os.Getenv("SERVE_ADDR"), ":8080"),
+		ServeIntervalMinutes: intWithDefault(os.Getenv("SERVE_INTERVAL_MINUTES"), 15),
+		ServeBranches:        boolWithDefault(os.Getenv("SERVE_BRANCHES"), false),
+		APIToken:             os.Getenv("API_TOKEN"),
+		WebhookSecret:        os.Getenv("WEBHOOK_SECRET"),
+
+		SlackWebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+		TeamsWebhookURL: os.Getenv("TEAMS_WEBHOOK_URL"),
+		NotifyTopN:      intWithDefault(os.Getenv("NOTIFY_TOP_N"), 10),
+		ChatHandlesPath: os.Getenv("CHAT_HANDLES"),
+
+		SMTPHost:            os.Getenv("SMTP_HOST"),
+		SMTPPort:            intWithDefault(os.Getenv("SMTP_PORT"), 587),
+		SMTPUsername:        os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:        os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:            os.Getenv("SMTP_FROM"),
+		SMTPTo:              splitList(os.Getenv("SMTP_TO")),
+		SMTPRequireStartTLS: boolWithDefault(os.Getenv("SMTP_REQUIRE_STARTTLS"), true),
+		EmailAuthors:        boolWithDefault(os.Getenv("EMAIL_AUTHORS"), false),
+		AuthorEmailsPath:    os.Getenv("AUTHOR_EMAILS"),
+
+		HistoryPath: os.Getenv("HISTORY_PATH"),
+
+		LogLevel:  withDefault(os.Getenv("LOG_LEVEL"), "info"),
+		LogFormat: withDefault(os.Getenv("LOG_FORMAT"), "text"),
+
+		OutputFormat:        withDefault(os.Getenv("OUTPUT_FORMAT"), "table"),
+		DiffBehindThreshold: intWithDefault(os.Getenv("DIFF_BEHIND_THRESHOLD"), 10),
+
+		PredictConflicts: boolWithDefault(os.Getenv("PREDICT_CONFLICTS"), false),
+		CompareDetails:   boolWithDefault(os.Getenv("COMPARE_DETAILS"), false),
+		ReviewStatus:     boolWithDefault(os.Getenv("REVIEW_STATUS"), false),
+		CIStatus:         boolWithDefault(os.Getenv("CI_STATUS"), false),
+		Codeowners:       boolWithDefault(os.Getenv("CODEOWNERS"), false),
+		CodeownersPath:   os.Getenv("CODEOWNERS_PATH"),
+		ReportFilter:     os.Getenv("REPORT_FILTER"),
+		GroupBy:          os.Getenv("GROUP_BY"),
+		MilestoneDueDays: intWithDefault(os.Getenv("MILESTONE_DUE_DAYS"), 14),
+
+		ReleaseBranches:     splitList(withDefault(os.Getenv("RELEASE_BRANCHES"), "release-*,stable/*")),
+		BackportLabelPrefix: withDefault(os.Getenv("BACKPORT_LABEL_PREFIX"), "backport/"),
+		BackportBranch:      withDefault(os.Getenv("BACKPORT_BRANCH"), "release-*"),
+		BackportDays:        intWithDefault(os.Getenv("BACKPORT_DAYS"), 30),
+
+		ActionLabels:  splitList(withDefault(os.Getenv("ACTION_LABELS"), "needs-rebase")),
+		ActionComment: withDefault(os.Getenv("ACTION_COMMENT"), "@{author} this pull request is {behind} commits behind {base}, could you rebase it?"),
 	}
 }
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// changedPaths keeps both names of the renamed files
+func changedPaths(files []GithubFile) map[string]bool {
+	paths := make(map[string]bool)
+	for _, f := range files {
+		paths[f.Filename] = true
+		if f.PreviousFilename != "" {
+			paths[f.PreviousFilename] = true
+		}
+	}
+	return paths
+}
+
+// PredictConflicts returns the paths changed both by the pull request and
+// on its base branch since their merge base, without attempting the merge.
+// The compare endpoint lists at most 300 files per side.
+func (a *AppMutex) PredictConflicts(baseRef string, headSha string) ([]string, error) {
+	head, err := a.CompareCommits(baseRef, headSha)
+	if err != nil {
+		return nil, err
+	}
+	if head.BehindBy == 0 || head.MergeBaseCommit.Sha == "" {
+		return []string{}, nil
+	}
+	base, err := a.CompareCommits(head.MergeBaseCommit.Sha, baseRef)
+	if err != nil {
+		return nil, err
+	}
+
+	baseChanges := changedPaths(base.Files)
+	overlap := []string{}
+	for path := range changedPaths(head.Files) {
+		if baseChanges[path] {
+			overlap = append(overlap, path)
+		}
+	}
+	sort.Strings(overlap)
+	return overlap, nil
+}
+
+// AddConflictPredictions costs two compare calls per pull request behind its
+// base branch
+func (a *AppMutex) AddConflictPredictions(report *Report) error {
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		if row.BehindBy == 0 {
+			row.LikelyConflicts = []string{}
+			continue
+		}
+		eg.Go(func() error {
+			paths, err := a.PredictConflicts(row.BaseRef, row.HeadSha)
+			if err != nil {
+				return err
+			}
+			row.LikelyConflicts = paths
+			return nil
+		})
+	}
+	report.ConflictsPredicted = true
+	return eg.Wait()
+}
+
+// AddCompareDetails lists the files changed by every pull request, through
+// the pull request files which go up to 3000 where the compare endpoint stops
+// at 300, and the commits of its base it is missing. The missing commits are
+// compared from the base commit the report used, as the behind count was.
+func (a *AppMutex) AddCompareDetails(report *Report) error {
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		eg.Go(func() error {
+			files, err := a.RequestPullRequestFiles(row.Number)
+			if err != nil {
+				return err
+			}
+			row.ChangedFiles = files
+			row.MissingCommits = []GithubCommit{}
+			if row.BehindBy == 0 {
+				return nil
+			}
+			base, err := a.CompareCommitsFull(row.HeadSha, row.BaseSha)
+			if err != nil {
+				return err
+			}
+			row.MissingCommits = base.Commits
+			return nil
+		})
+	}
+	return eg.Wait()
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Dashboard renders the snapshot as an HTML page
+type Dashboard struct {
+	Snapshot *Snapshot
+}
+
+func (d *Dashboard) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/", d.serveIndex)
+	mux.HandleFunc("/refresh", d.serveRefresh)
+}
+
+func (d *Dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, map[string]interface{}{
+		"Reports":     d.Snapshot.Reports(),
+		"Errors":      d.Snapshot.Errors(),
+		"RefreshedAt": d.Snapshot.RefreshedAt(),
+	})
+	if err != nil {
+		slog.Warn("Could not render the dashboard", "error", err)
+	}
+}
+
+func (d *Dashboard) serveRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d.Snapshot.RequestRefresh()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// behindBucket groups the pull requests by how far behind their base they
+// are, for the dashboard colors and the metrics
+func behindBucket(behindBy int) int {
+	switch {
+	case behindBy == 0:
+		return 0
+	case behindBy <= 10:
+		return 1
+	case behindBy <= 50:
+		return 2
+	default:
+		return 3
+	}
+}
+
+var behindBucketLabels = []string{"0", "1-10", "11-50", "50+"}
+var behindBucketClasses = []string{"uptodate", "behind", "outdated", "stale"}
+
+func behindClass(behindBy int) string {
+	return behindBucketClasses[behindBucket(behindBy)]
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"behindClass": behindClass,
+	"join":        strings.Join,
+	"date":        func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Outdated Branches</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+th { cursor: pointer; background: #f4f4f4; }
+tr.uptodate { background: #e6ffed; }
+tr.behind { background: #fffbdd; }
+tr.outdated { background: #ffe8cc; }
+tr.stale { background: #ffdce0; }
+.error { color: #b31d28; }
+</style>
+</head>
+<body>
+<h1>Outdated Branches</h1>
+<form method="post" action="/refresh">
+Last refreshed: {{if .RefreshedAt.IsZero}}never{{else}}{{date .RefreshedAt}}{{end}}
+<button type="submit">Refresh</button>
+</form>
+{{range $repo, $err := .Errors}}<p class="error">{{$repo}}: {{$err}}</p>{{end}}
+{{range .Reports}}
+<h2>{{.Repo}}</h2>
+<input type="search" placeholder="Filter" oninput="filterTable(this)">
+<table>
+<thead><tr>
+<th onclick="sortTable(this, true)">PR</th>
+<th onclick="sortTable(this)">Title</th>
+<th onclick="sortTable(this)">Author</th>
+<th onclick="sortTable(this)">Branch</th>
+<th onclick="sortTable(this)">Base Branch</th>
+<th onclick="sortTable(this, true)">Ahead</th>
+<th onclick="sortTable(this, true)">Behind</th>
+<th onclick="sortTable(this)">Created At</th>
+{{if .ReviewsFetched}}<th onclick="sortTable(this)">Reviews</th>{{end}}
+{{if .CIFetched}}<th onclick="sortTable(this)">CI</th>{{end}}
+{{if .OwnersFetched}}<th onclick="sortTable(this)">Owners</th>{{end}}
+</tr></thead>
+<tbody>
+{{$reviews := .ReviewsFetched}}{{$ci := .CIFetched}}{{$owners := .OwnersFetched}}{{range .Rows}}<tr class="{{behindClass .BehindBy}}">
+<td data-value="{{.Number}}"><a href="{{.HTMLURL}}">#{{.Number}}</a></td>
+<td>{{.Title}}</td>
+<td>{{.Author}}</td>
+<td>{{.HeadRef}}</td>
+<td>{{.BaseRef}}</td>
+<td data-value="{{.AheadBy}}">{{.AheadBy}}</td>
+<td data-value="{{.BehindBy}}">{{.BehindBy}}</td>
+<td>{{date .CreatedAt}}</td>
+{{if $reviews}}<td>{{.ReviewSummary}}</td>{{end}}
+{{if $ci}}<td>{{.CIStatus}}</td>{{end}}
+{{if $owners}}<td>{{join .Owners " "}}</td>{{end}}
+</tr>
+{{end}}</tbody>
+</table>
+{{end}}
+<script>
+function sortTable(th, numeric) {
+  var table = th.closest("table"), body = table.tBodies[0];
+  var index = Array.prototype.indexOf.call(th.parentNode.children, th);
+  var asc = th.dataset.order !== "asc";
+  th.dataset.order = asc ? "asc" : "desc";
+  var rows = Array.prototype.slice.call(body.rows);
+  rows.sort(function (a, b) {
+    var x = a.cells[index].dataset.value || a.cells[index].textContent;
+    var y = b.cells[index].dataset.value || b.cells[index].textContent;
+    var cmp = numeric ? x - y : x.localeCompare(y);
+    return asc ? cmp : -cmp;
+  });
+  rows.forEach(function (row) { body.appendChild(row); });
+}
+function filterTable(input) {
+  var table = input.nextElementSibling, query = input.value.toLowerCase();
+  Array.prototype.forEach.call(table.tBodies[0].rows, function (row) {
+    row.style.display = row.textContent.toLowerCase().indexOf(query) === -1 ? "none" : "";
+  });
+}
+</script>
+</body>
+</html>
+`))
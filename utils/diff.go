@@ -0,0 +1,86 @@
+package utils
+
+import "time"
+
+// DiffEntry describes a pull request which changed since the previous run,
+// the previous values are zero for the newly opened ones
+type DiffEntry struct {
+	Number           int    `json:"number"`
+	Title            string `json:"title,omitempty"`
+	Author           string `json:"author"`
+	HTMLURL          string `json:"html_url,omitempty"`
+	BaseRef          string `json:"base_ref"`
+	PreviousBehindBy int    `json:"previous_behind_by"`
+	BehindBy         int    `json:"behind_by"`
+}
+
+// RunDiff lists what changed between the previous run and the current report
+type RunDiff struct {
+	Repo      string      `json:"repo"`
+	Since     time.Time   `json:"since"`
+	Threshold int         `json:"threshold"`
+	Opened    []DiffEntry `json:"opened"`
+	Outdated  []DiffEntry `json:"outdated"`
+	Rebased   []DiffEntry `json:"rebased"`
+	Closed    []DiffEntry `json:"closed"`
+}
+
+// DiffRuns compares the current report with the previous run: a pull request
+// is outdated when it crossed the threshold of commits behind, and rebased
+// when it is not behind anymore. The closed ones cannot be told apart from
+// the merged ones without further API calls.
+func DiffRuns(previous *HistoryRun, current *Report, threshold int) *RunDiff {
+	diff := RunDiff{
+		Repo:      current.Repo,
+		Threshold: threshold,
+		Opened:    []DiffEntry{},
+		Outdated:  []DiffEntry{},
+		Rebased:   []DiffEntry{},
+		Closed:    []DiffEntry{},
+	}
+	before := make(map[int]HistoryEntry)
+	if previous != nil {
+		diff.Since = previous.RecordedAt
+		for _, pr := range previous.PullRequests {
+			before[pr.Number] = pr
+		}
+	}
+
+	for _, row := range current.Rows {
+		entry := DiffEntry{
+			Number:   row.Number,
+			Title:    row.Title,
+			Author:   row.Author,
+			HTMLURL:  row.HTMLURL,
+			BaseRef:  row.BaseRef,
+			BehindBy: row.BehindBy,
+		}
+		prev, found := before[row.Number]
+		delete(before, row.Number)
+		if !found {
+			diff.Opened = append(diff.Opened, entry)
+			continue
+		}
+		entry.PreviousBehindBy = prev.BehindBy
+		switch {
+		case prev.BehindBy < threshold && row.BehindBy >= threshold:
+			diff.Outdated = append(diff.Outdated, entry)
+		case prev.BehindBy > 0 && row.BehindBy == 0:
+			diff.Rebased = append(diff.Rebased, entry)
+		}
+	}
+
+	if previous != nil {
+		for _, pr := range previous.PullRequests {
+			if _, closed := before[pr.Number]; closed {
+				diff.Closed = append(diff.Closed, DiffEntry{
+					Number:           pr.Number,
+					Author:           pr.Author,
+					BaseRef:          pr.BaseRef,
+					PreviousBehindBy: pr.BehindBy,
+				})
+			}
+		}
+	}
+	return &diff
+}
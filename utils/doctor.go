@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DoctorCheck is the outcome of one of the checks of the configuration,
+// Error being empty when it passed
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (c DoctorCheck) OK() bool {
+	return c.Error == ""
+}
+
+func newDoctorCheck(name string, detail string, err error) DoctorCheck {
+	check := DoctorCheck{Name: name, Detail: detail}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func oneOf(value string, values []string) error {
+	for _, v := range values {
+		if v == value {
+			return nil
+		}
+	}
+	return errors.New(strconv.Quote(value) + " is not one of " + strings.Join(values, ", "))
+}
+
+// checkRepository reads the repository through the configured provider: the
+// repository itself on Github, its open change requests on the other forges
+func (a *AppMutex) checkRepository() (string, error) {
+	if a.Config.Provider != "github" {
+		provider, err := NewProvider(a)
+		if err != nil {
+			return "", err
+		}
+		pullRequests, err := provider.ListChangeRequests()
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(len(pullRequests)) + " open change requests", nil
+	}
+	resp, err := a.doRequest(a.ApiRepository())
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	detail := "reachable"
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+		detail += ", " + remaining + " requests left"
+	}
+	defaultBranch, err := a.GetDefaultBranch()
+	if err != nil {
+		return "", err
+	}
+	return detail + ", default branch " + defaultBranch, nil
+}
+
+func (a *AppMutex) checkGraphql() (string, error) {
+	result := struct{}{}
+	rateLimit, err := a.GraphqlQuery("query { rateLimit { cost remaining resetAt } }", nil, &result)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(rateLimit.Remaining) + " points left", nil
+}
+
+func checkSMTP(config *Config) (string, error) {
+	if config.SMTPFrom == "" {
+		return "", errors.New("SMTP_FROM is not set")
+	}
+	if len(config.SMTPTo) == 0 && !config.EmailAuthors {
+		return "", errors.New("neither SMTP_TO nor EMAIL_AUTHORS is set")
+	}
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return addr + " reachable", nil
+}
+
+// checkHistory reads the recorded runs and makes sure new ones can be
+// written next to them
+func checkHistory(config *Config) (string, error) {
+	runs, err := HistoryStore{Path: config.HistoryPath}.Runs(config.Repo())
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(config.HistoryPath)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errors.New(dir + " is not a directory")
+	}
+	return strconv.Itoa(len(runs)) + " recorded runs in " + config.HistoryPath, nil
+}
+
+// Doctor checks the configuration, and that every service it points to can
+// be reached, without changing anything
+func (a *AppMutex) Doctor() []DoctorCheck {
+	config := a.Config
+	checks := []DoctorCheck{}
+	add := func(name string, detail string, err error) {
+		checks = append(checks, newDoctorCheck(name, detail, err))
+	}
+
+	_, err := NewProvider(a)
+	add("provider", config.Provider+" "+config.Repo(), err)
+	if config.Token() == "" {
+		add("token", "", errors.New("the token of the "+config.Provider+" provider is not set"))
+	} else {
+		add("token", "set", nil)
+		detail, err := a.checkRepository()
+		add("api", detail, err)
+	}
+	add("output format", config.OutputFormat, oneOf(config.OutputFormat, OutputFormats))
+	if config.ReportFilter != "" {
+		add("report filter", config.ReportFilter, oneOf(config.ReportFilter, ReportFilterNames()))
+	}
+	if config.GroupBy != "" {
+		add("group by", config.GroupBy, oneOf(config.GroupBy, []string{"milestone", "stack"}))
+	}
+	if config.DataSource == "graphql" {
+		detail, err := a.checkGraphql()
+		add("graphql", detail, err)
+	}
+	if config.LocalGitDir != "" {
+		sha, err := NewLocalGit(config.LocalGitDir, config.LocalGitRemote).ResolveRef("HEAD")
+		add("local git", config.LocalGitDir+" at "+shortSha(sha), err)
+	}
+	if config.HistoryEnabled() {
+		detail, err := checkHistory(config)
+		add("history", detail, err)
+	}
+	if config.SMTPHost != "" {
+		detail, err := checkSMTP(config)
+		add("smtp", detail, err)
+	}
+	loginMaps := []struct{ name, path string }{
+		{"chat handles", config.ChatHandlesPath},
+		{"author emails", config.AuthorEmailsPath},
+	}
+	for _, loginMap := range loginMaps {
+		if loginMap.path == "" {
+			continue
+		}
+		logins, err := LoadLoginMap(loginMap.path)
+		add(loginMap.name, strconv.Itoa(len(logins))+" logins in "+loginMap.path, err)
+	}
+	if config.Codeowners && config.Token() != "" {
+		codeowners, err := a.LoadCodeowners(config.CodeownersPath)
+		add("codeowners", strconv.Itoa(len(codeowners))+" rules", err)
+	}
+	return checks
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EmailNotifier sends the digests over SMTP, as multipart messages with a
+// plain text and an HTML body
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+	// RequireStartTLS refuses to send when the server does not offer STARTTLS
+	RequireStartTLS bool
+}
+
+func NewEmailNotifier(config *Config) EmailNotifier {
+	return EmailNotifier{
+		Host:            config.SMTPHost,
+		Port:            config.SMTPPort,
+		Username:        config.SMTPUsername,
+		Password:        config.SMTPPassword,
+		From:            config.SMTPFrom,
+		To:              config.SMTPTo,
+		RequireStartTLS: config.SMTPRequireStartTLS,
+	}
+}
+
+var emailTextTemplate = template.Must(template.New("text").Parse(`{{.Intro}}
+{{range .Rows}}
+* #{{.Number}} {{.Title}} ({{.Author}}): {{.BehindBy}} commits behind {{.BaseRef}}
+  {{.HTMLURL}}
+{{end}}`))
+
+var emailHTMLTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<p>{{.Intro}}</p>
+<ul>
+{{range .Rows}}<li><a href="{{.HTMLURL}}">#{{.Number}} {{.Title}}</a> ({{.Author}}): {{.BehindBy}} commits behind {{.BaseRef}}</li>
+{{end}}</ul>`))
+
+// buildMessage renders the rows in both formats into a multipart/alternative
+// message. The parts are quoted-printable, so that the titles and names out
+// of ASCII survive the servers only passing 7bit.
+func (e EmailNotifier) buildMessage(to []string, subject string, intro string, rows []ReportRow) ([]byte, error) {
+	data := map[string]interface{}{"Intro": intro, "Rows": rows}
+	var text, html bytes.Buffer
+	if err := emailTextTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := emailHTMLTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text.String()},
+		{"text/html; charset=utf-8", html.String()},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.content))
+		qp.Close()
+	}
+	writer.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// send upgrades the connection with STARTTLS whenever the server offers it,
+// credentials are never sent in clear text by smtp.PlainAuth
+func (e EmailNotifier) send(to []string, msg []byte) error {
+	client, err := smtp.Dial(net.JoinHostPort(e.Host, strconv.Itoa(e.Port)))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+			return err
+		}
+	} else if e.RequireStartTLS {
+		return errors.New("smtp: " + e.Host + " does not support STARTTLS")
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Notify sends the team-wide digest
+func (e EmailNotifier) Notify(digest Digest) error {
+	msg, err := e.buildMessage(e.To, "Outdated pull requests on "+digest.Repo, digest.Summary(), digest.Top)
+	if err != nil {
+		return err
+	}
+	return e.send(e.To, msg)
+}
+
+// NotifyAuthor sends to a single author the list of their outdated pull
+// requests
+func (e EmailNotifier) NotifyAuthor(email string, repo string, rows []ReportRow) error {
+	intro := fmt.Sprintf("You have %d pull request(s) behind their base branch on %s.", len(rows), repo)
+	msg, err := e.buildMessage([]string{email}, "Your outdated pull requests on "+repo, intro, rows)
+	if err != nil {
+		return err
+	}
+	return e.send([]string{email}, msg)
+}
+
+// NotifyOwner sends to a CODEOWNERS owner the outdated pull requests
+// changing the files they own
+func (e EmailNotifier) NotifyOwner(email string, owner string, repo string, rows []ReportRow) error {
+	intro := fmt.Sprintf("%d pull request(s) changing files owned by %s are behind their base branch on %s.", len(rows), owner, repo)
+	msg, err := e.buildMessage([]string{email}, "Outdated pull requests owned by "+owner+" on "+repo, intro, rows)
+	if err != nil {
+		return err
+	}
+	return e.send([]string{email}, msg)
+}
+
+// OutdatedByAuthor groups the pull requests behind their base by author
+func OutdatedByAuthor(report *Report) map[string][]ReportRow {
+	byAuthor := make(map[string][]ReportRow)
+	for _, row := range report.Rows {
+		if row.BehindBy > 0 {
+			byAuthor[row.Author] = append(byAuthor[row.Author], row)
+		}
+	}
+	return byAuthor
+}
+
+// OutdatedByOwner groups the pull requests behind their base by CODEOWNERS
+// owner
+func OutdatedByOwner(report *Report) map[string][]ReportRow {
+	byOwner := make(map[string][]ReportRow)
+	for _, row := range report.Rows {
+		if row.BehindBy == 0 {
+			continue
+		}
+		for _, owner := range row.Owners {
+			byOwner[owner] = append(byOwner[owner], row)
+		}
+	}
+	return byOwner
+}
+
+// OwnerEmail returns the owners written as email addresses as they are, and
+// looks the users and teams up in the mapping file without their "@"
+func OwnerEmail(emails map[string]string, owner string) (string, error) {
+	if !strings.HasPrefix(owner, "@") && strings.Contains(owner, "@") {
+		return owner, nil
+	}
+	if email, found := emails[strings.TrimPrefix(owner, "@")]; found && email != "" {
+		return email, nil
+	}
+	return "", errors.New("no email mapped for " + owner)
+}
+
+// AuthorEmail looks the login up in the mapping file first, then falls back
+// to the email of the latest commit of the pull request written by its
+// author. The commits of co-authors, such as a rebase by a maintainer, and
+// the Github noreply addresses are skipped.
+func (a *AppMutex) AuthorEmail(emails map[string]string, row ReportRow) (string, error) {
+	if email, found := emails[row.Author]; found && email != "" {
+		return email, nil
+	}
+	if a.Config.Provider != "github" {
+		return "", errors.New("no email mapped for " + row.Author)
+	}
+	commits, err := a.RequestPullRequestCommits(row.Number)
+	if err != nil {
+		return "", err
+	}
+	for i := len(commits) - 1; i >= 0; i-- {
+		email := commits[i].Commit.Author.Email
+		if strings.EqualFold(commits[i].Author.Login, row.Author) && email != "" && !strings.HasSuffix(strings.ToLower(email), "noreply.github.com") {
+			return email, nil
+		}
+	}
+	return "", errors.New("no email found for " + row.Author)
+}
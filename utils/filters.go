@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type reportFilter struct {
+	match func(ReportRow) bool
+	// fetched tells whether the report holds the data the filter needs, and
+	// option is the setting filling it
+	fetched func(*Report) bool
+	option  string
+}
+
+// ReportFilters are the values accepted by REPORT_FILTER and by the filter
+// parameter of the API
+var ReportFilters = map[string]reportFilter{
+	"approved-behind": {
+		match:   ReportRow.ApprovedButBehind,
+		fetched: func(r *Report) bool { return r.ReviewsFetched },
+		option:  "REVIEW_STATUS=true",
+	},
+	"green-behind": ciFilter(ReportRow.GreenButBehind),
+	"ci-success":   ciFilter(func(row ReportRow) bool { return row.CIStatus == "success" }),
+	"ci-failure":   ciFilter(func(row ReportRow) bool { return row.CIStatus == "failure" }),
+	"ci-pending":   ciFilter(func(row ReportRow) bool { return row.CIStatus == "pending" }),
+	"ci-none":      ciFilter(func(row ReportRow) bool { return row.CIStatus == "none" }),
+}
+
+func ciFilter(match func(ReportRow) bool) reportFilter {
+	return reportFilter{
+		match:   match,
+		fetched: func(r *Report) bool { return r.CIFetched },
+		option:  "CI_STATUS=true",
+	}
+}
+
+// ReportFilterNames lists the filters in a stable order for the error
+// messages
+func ReportFilterNames() []string {
+	names := []string{}
+	for name := range ReportFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FilterReport returns a copy of the report keeping the rows matching the
+// named filter, the report itself when the name is empty
+func FilterReport(report *Report, name string) (*Report, error) {
+	if name == "" {
+		return report, nil
+	}
+	filter, found := ReportFilters[name]
+	if !found {
+		return nil, errors.Errorf("unknown filter %s, expected one of %v", name, ReportFilterNames())
+	}
+	if !filter.fetched(report) {
+		return nil, errors.Errorf("filter %s needs %s", name, filter.option)
+	}
+	filtered := *report
+	filtered.Rows = []ReportRow{}
+	for _, row := range report.Rows {
+		if filter.match(row) {
+			filtered.Rows = append(filtered.Rows, row)
+		}
+	}
+	return &filtered, nil
+}
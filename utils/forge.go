@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// forgeClient performs the authenticated JSON calls shared by the providers
+// of the forges other than Github
+type forgeClient struct {
+	Client     *http.Client
+	authHeader string
+	authValue  string
+}
+
+func (f *forgeClient) newRequest(method string, url string, body interface{}) *http.Request {
+	var reader io.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		Fatal("forge newRequest", "error", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(f.authHeader, f.authValue)
+	return req
+}
+
+func (f *forgeClient) getJSON(url string, result interface{}) (*http.Response, error) {
+	resp, err := f.Client.Do(f.newRequest("GET", url, nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return resp, json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (f *forgeClient) write(method string, url string, body interface{}) error {
+	resp, err := f.Client.Do(f.newRequest(method, url, body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: unexpected status %s", method, url, resp.Status)
+	}
+	return nil
+}
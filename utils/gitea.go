@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const giteaPageSize = 50
+
+// https://gitea.com/api/swagger#/repository/repoListPullRequests
+type GiteaPullRequest struct {
+	ID        int       `json:"id"`
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	HTMLURL   string    `json:"html_url"`
+	CreatedAt time.Time `json:"created_at"`
+	Draft     bool      `json:"draft"`
+	Mergeable bool      `json:"mergeable"`
+	User      struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Milestone *struct {
+		Title string     `json:"title"`
+		DueOn *time.Time `json:"due_on"`
+	} `json:"milestone"`
+	Head struct {
+		Ref    string `json:"ref"`
+		Sha    string `json:"sha"`
+		RepoID int    `json:"repo_id"`
+	} `json:"head"`
+	Base struct {
+		Ref    string `json:"ref"`
+		Sha    string `json:"sha"`
+		RepoID int    `json:"repo_id"`
+	} `json:"base"`
+}
+
+// https://gitea.com/api/swagger#/repository/repoCompareDiff
+type GiteaCompare struct {
+	TotalCommits int `json:"total_commits"`
+}
+
+func (p GiteaPullRequest) ToPullRequest() GithubPullRequest {
+	mergeable := p.Mergeable
+	pr := GithubPullRequest{
+		ID:        p.ID,
+		Number:    p.Number,
+		Title:     p.Title,
+		State:     p.State,
+		HTMLURL:   p.HTMLURL,
+		CreatedAt: p.CreatedAt,
+		Draft:     p.Draft,
+		Mergeable: &mergeable,
+		User:      GithubUser{Login: p.User.Login},
+	}
+	for _, label := range p.Labels {
+		pr.Labels = append(pr.Labels, GithubLabel{Name: label.Name})
+	}
+	if p.Milestone != nil {
+		pr.Milestone.Title = p.Milestone.Title
+		if p.Milestone.DueOn != nil {
+			pr.Milestone.DueOn = *p.Milestone.DueOn
+		}
+	}
+	pr.Head.Ref = p.Head.Ref
+	pr.Head.Sha = p.Head.Sha
+	pr.Head.Repo.ID = p.Head.RepoID
+	pr.Base.Ref = p.Base.Ref
+	pr.Base.Sha = p.Base.Sha
+	pr.Base.Repo.ID = p.Base.RepoID
+	return pr
+}
+
+// GiteaProvider speaks the v1 API shared by Gitea and Forgejo
+type GiteaProvider struct {
+	forgeClient
+	Config *Config
+}
+
+func NewGiteaProvider(config *Config, client *http.Client) *GiteaProvider {
+	return &GiteaProvider{
+		forgeClient: forgeClient{Client: client, authHeader: "Authorization", authValue: "token " + config.GiteaToken},
+		Config:      config,
+	}
+}
+
+func (g *GiteaProvider) apiURL(format string, args ...interface{}) string {
+	return strings.TrimSuffix(g.Config.ProviderURL, "/") + "/api/v1/repos/" + g.Config.RepoAuthor + "/" + g.Config.RepoName + fmt.Sprintf(format, args...)
+}
+
+// ListChangeRequests stops at the first page shorter than the page size
+func (g *GiteaProvider) ListChangeRequests() (PullRequestList, error) {
+	pullRequests := PullRequestList{}
+	for page := 1; ; page++ {
+		giteaPullRequests := []GiteaPullRequest{}
+		if _, err := g.getJSON(g.apiURL("/pulls?state=open&limit=%d&page=%d", giteaPageSize, page), &giteaPullRequests); err != nil {
+			return nil, errors.Wrap(err, "listChangeRequests")
+		}
+		for _, pr := range giteaPullRequests {
+			pullRequests = append(pullRequests, pr.ToPullRequest())
+		}
+		if len(giteaPullRequests) < giteaPageSize {
+			return pullRequests, nil
+		}
+	}
+}
+
+func (g *GiteaProvider) BranchHead(branch string) (string, error) {
+	b := struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}{}
+	if _, err := g.getJSON(g.apiURL("/branches/%s", url.PathEscape(branch)), &b); err != nil {
+		return "", errors.Wrap(err, "branchHead")
+	}
+	return b.Commit.ID, nil
+}
+
+func (g *GiteaProvider) PullRequestHead(pr GithubPullRequest) (string, error) {
+	return pr.Head.Sha, nil
+}
+
+func (g *GiteaProvider) countCommits(base string, head string) (int, error) {
+	compare := GiteaCompare{}
+	if _, err := g.getJSON(g.apiURL("/compare/%s...%s", url.PathEscape(base), url.PathEscape(head)), &compare); err != nil {
+		return 0, errors.Wrap(err, "compareCommits")
+	}
+	return compare.TotalCommits, nil
+}
+
+// CompareCommits needs one compare call per direction, the API only reports
+// the commits of the head
+func (g *GiteaProvider) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	aheadBy, err := g.countCommits(baseSha, headSha)
+	if err != nil {
+		return nil, err
+	}
+	behindBy, err := g.countCommits(headSha, baseSha)
+	if err != nil {
+		return nil, err
+	}
+	return &GithubCommitCompare{
+		Status:       compareStatus(aheadBy, behindBy),
+		AheadBy:      aheadBy,
+		BehindBy:     behindBy,
+		TotalCommits: aheadBy,
+	}, nil
+}
+
+func (g *GiteaProvider) AddLabels(number int, labels []string) error {
+	return g.write("POST", g.apiURL("/issues/%d/labels", number), map[string][]string{"labels": labels})
+}
+
+func (g *GiteaProvider) AddComment(number int, comment string) error {
+	return g.write("POST", g.apiURL("/issues/%d/comments", number), map[string]string{"body": comment})
+}
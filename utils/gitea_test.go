@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func giteaPullRequest(number int, repoID int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         number + 1000,
+		"number":     number,
+		"title":      "PR " + strconv.Itoa(number),
+		"state":      "open",
+		"html_url":   "https://gitea.example.com/group/project/pulls/" + strconv.Itoa(number),
+		"created_at": "2019-03-01T10:00:00Z",
+		"mergeable":  true,
+		"user":       map[string]string{"login": "alice"},
+		"labels":     []map[string]string{{"name": "bug"}},
+		"head":       map[string]interface{}{"ref": "feature-" + strconv.Itoa(number), "sha": "head-" + strconv.Itoa(number), "repo_id": repoID},
+		"base":       map[string]interface{}{"ref": "master", "sha": "base", "repo_id": 1},
+	}
+}
+
+// TestGiteaProviderReport lists a first full page of 50 pull requests and a
+// second one, the head of #N being N % 3 commits ahead and one behind
+func TestGiteaProviderReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		const prefix = "/api/v1/repos/group/project"
+		switch {
+		case r.URL.Path == prefix+"/pulls":
+			if r.URL.Query().Get("limit") != "50" {
+				t.Errorf("pulls listed by %s", r.URL.Query().Get("limit"))
+			}
+			pullRequests := []interface{}{}
+			if r.URL.Query().Get("page") == "1" {
+				for number := 1; number <= 50; number++ {
+					pullRequests = append(pullRequests, giteaPullRequest(number, 1))
+				}
+			} else {
+				pullRequests = append(pullRequests, giteaPullRequest(51, 7))
+			}
+			json.NewEncoder(w).Encode(pullRequests)
+		case strings.HasPrefix(r.URL.Path, prefix+"/compare/"):
+			refs := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix+"/compare/"), "...", 2)
+			totalCommits := 1
+			if refs[0] == "base" {
+				number, _ := strconv.Atoi(strings.TrimPrefix(refs[1], "head-"))
+				totalCommits = number % 3
+			}
+			json.NewEncoder(w).Encode(map[string]int{"total_commits": totalCommits})
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	app := newForgeApp("gitea", server.URL)
+	provider, err := NewProvider(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullRequests, err := provider.ListChangeRequests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pullRequests) != 51 {
+		t.Fatalf("%d pull requests, want 51", len(pullRequests))
+	}
+	for number, c := range compareAll(t, provider, pullRequests) {
+		if c.AheadBy != number%3 || c.BehindBy != 1 {
+			t.Errorf("#%d: %d ahead, %d behind, want %d ahead, 1 behind", number, c.AheadBy, c.BehindBy, number%3)
+		}
+	}
+	for _, pr := range pullRequests {
+		if pr.FromFork() != (pr.Number == 51) {
+			t.Errorf("#%d: from a fork %t", pr.Number, pr.FromFork())
+		}
+	}
+	if pr := pullRequests[0]; pr.User.Login != "alice" || pr.Head.Ref != "feature-1" || len(pr.Labels) != 1 {
+		t.Errorf("#1 mapped as %q by %s from %s", pr.Title, pr.User.Login, pr.Head.Ref)
+	}
+}
+
+func TestGiteaProviderWrites(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		payload, _ := json.Marshal(body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(payload))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	provider := NewGiteaProvider(newForgeApp("gitea", server.URL).Config, http.DefaultClient)
+	if err := provider.AddLabels(4, []string{"outdated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.AddComment(4, "Please rebase"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`POST /api/v1/repos/group/project/issues/4/labels {"labels":["outdated"]}`,
+		`POST /api/v1/repos/group/project/issues/4/comments {"body":"Please rebase"}`,
+	}
+	if len(requests) != 2 || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("requests %q, want %q", requests, want)
+	}
+}
@@ -1,6 +1,9 @@
 package utils
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // https://developer.github.com/v3/pulls/#pull-requests
 type GithubUser struct {
@@ -213,6 +216,19 @@ type GithubPullRequest struct {
 	} `json:"base"`
 	Links             GithubLinks `json:"_links"`
 	AuthorAssociation string      `json:"author_association"`
+	Draft             bool        `json:"draft"`
+	// Only returned when fetching a single pull request, nil while unknown
+	Mergeable *bool `json:"mergeable"`
+}
+
+// FromFork tells the pull requests whose head branch is in another repository
+// than their base, including the forks since deleted. The repositories are
+// compared by ID when the provider gives one, by name otherwise.
+func (pr GithubPullRequest) FromFork() bool {
+	if pr.Head.Repo.ID != 0 || pr.Base.Repo.ID != 0 {
+		return pr.Head.Repo.ID != pr.Base.Repo.ID
+	}
+	return !strings.EqualFold(pr.Head.Repo.FullName, pr.Base.Repo.FullName)
 }
 
 // Subset of branch response for parsing purposes
@@ -246,11 +262,16 @@ type GithubCommit struct {
 	} `json:"commit"`
 	Author    GithubUser `json:"author"`
 	Committer GithubUser `json:"committer"`
+	Parents   []struct {
+		URL string `json:"url"`
+		Sha string `json:"sha"`
+	} `json:"parents"`
 }
 
 type GithubBranch struct {
-	Name   string       `json:"name"`
-	Commit GithubCommit `json:"commit"`
+	Name      string       `json:"name"`
+	Commit    GithubCommit `json:"commit"`
+	Protected bool         `json:"protected"`
 }
 
 // https://developer.github.com/v3/repos/commits/#compare-two-commits
@@ -265,20 +286,83 @@ type GithubFile struct {
 	RawURL      string `json:"raw_url"`
 	ContentsURL string `json:"contents_url"`
 	Patch       string `json:"patch"`
+	// Only set for the renamed files
+	PreviousFilename string `json:"previous_filename"`
 }
 
 type GithubCommitCompare struct {
-	URL             string       `json:"url"`
-	HTMLURL         string       `json:"html_url"`
-	PermalinkURL    string       `json:"permalink_url"`
-	DiffURL         string       `json:"diff_url"`
-	PatchURL        string       `json:"patch_url"`
-	BaseCommit      GithubCommit `json:"base_commit"`
-	MergeBaseCommit GithubCommit `json:"merge_base_commit"`
-	Status          string       `json:"status"`
-	AheadBy         int          `json:"ahead_by"`
-	BehindBy        int          `json:"behind_by"`
-	TotalCommits    int          `json:"total_commits"`
-	// Commits         []GithubCommit `json:"commits"`
-	// Files           []GithubFile `json:"files"`
+	URL             string         `json:"url"`
+	HTMLURL         string         `json:"html_url"`
+	PermalinkURL    string         `json:"permalink_url"`
+	DiffURL         string         `json:"diff_url"`
+	PatchURL        string         `json:"patch_url"`
+	BaseCommit      GithubCommit   `json:"base_commit"`
+	MergeBaseCommit GithubCommit   `json:"merge_base_commit"`
+	Status          string         `json:"status"`
+	AheadBy         int            `json:"ahead_by"`
+	BehindBy        int            `json:"behind_by"`
+	TotalCommits    int            `json:"total_commits"`
+	Commits         []GithubCommit `json:"commits"`
+	Files           []GithubFile   `json:"files"`
+}
+
+// https://developer.github.com/v3/pulls/reviews/
+type GithubReview struct {
+	ID          int        `json:"id"`
+	NodeID      string     `json:"node_id"`
+	User        GithubUser `json:"user"`
+	Body        string     `json:"body"`
+	State       string     `json:"state"`
+	HTMLURL     string     `json:"html_url"`
+	CommitID    string     `json:"commit_id"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+}
+
+// https://developer.github.com/v3/repos/statuses/#get-the-combined-status-for-a-specific-ref
+type GithubStatus struct {
+	ID          int       `json:"id"`
+	State       string    `json:"state"`
+	Context     string    `json:"context"`
+	Description string    `json:"description"`
+	TargetURL   string    `json:"target_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type GithubCombinedStatus struct {
+	State      string         `json:"state"`
+	Sha        string         `json:"sha"`
+	TotalCount int            `json:"total_count"`
+	Statuses   []GithubStatus `json:"statuses"`
+}
+
+// https://developer.github.com/v3/checks/runs/#list-check-runs-for-a-git-reference
+type GithubCheckRun struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	HeadSha     string    `json:"head_sha"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	HTMLURL     string    `json:"html_url"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type GithubCheckRuns struct {
+	TotalCount int              `json:"total_count"`
+	CheckRuns  []GithubCheckRun `json:"check_runs"`
+}
+
+// https://developer.github.com/v3/repos/contents/#get-contents
+type GithubContent struct {
+	Type        string `json:"type"`
+	Encoding    string `json:"encoding"`
+	Size        int    `json:"size"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Content     string `json:"content"`
+	Sha         string `json:"sha"`
+	URL         string `json:"url"`
+	HTMLURL     string `json:"html_url"`
+	DownloadURL string `json:"download_url"`
 }
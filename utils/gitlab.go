@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://docs.gitlab.com/ee/api/merge_requests.html
+type GitlabMergeRequest struct {
+	ID           int       `json:"id"`
+	IID          int       `json:"iid"`
+	Title        string    `json:"title"`
+	State        string    `json:"state"`
+	WebURL       string    `json:"web_url"`
+	CreatedAt    time.Time `json:"created_at"`
+	Draft        bool      `json:"draft"`
+	Labels       []string  `json:"labels"`
+	SourceBranch string    `json:"source_branch"`
+	TargetBranch string    `json:"target_branch"`
+	Sha          string    `json:"sha"`
+	Author       struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Milestone *struct {
+		Title string `json:"title"`
+		// DueDate is a date without time, e.g. "2019-03-01"
+		DueDate string `json:"due_date"`
+	} `json:"milestone"`
+	DiffRefs struct {
+		BaseSha  string `json:"base_sha"`
+		HeadSha  string `json:"head_sha"`
+		StartSha string `json:"start_sha"`
+	} `json:"diff_refs"`
+	// The source project is another one for the merge requests from forks
+	SourceProjectID int `json:"source_project_id"`
+	TargetProjectID int `json:"target_project_id"`
+	// Only returned with include_diverged_commits_count=true: the commits of
+	// the current tip of the target branch missing from the source branch
+	DivergedCommitsCount int `json:"diverged_commits_count"`
+}
+
+// https://docs.gitlab.com/ee/api/branches.html
+type GitlabBranch struct {
+	Name      string `json:"name"`
+	Protected bool   `json:"protected"`
+	Commit    struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+// https://docs.gitlab.com/ee/api/repositories.html#compare-branches-tags-or-commits
+type GitlabCompare struct {
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"commits"`
+	CompareSameRef bool `json:"compare_same_ref"`
+}
+
+func (mr GitlabMergeRequest) ToPullRequest() GithubPullRequest {
+	pr := GithubPullRequest{
+		ID:        mr.ID,
+		Number:    mr.IID,
+		Title:     mr.Title,
+		State:     "open",
+		HTMLURL:   mr.WebURL,
+		CreatedAt: mr.CreatedAt,
+		Draft:     mr.Draft,
+		User:      GithubUser{Login: mr.Author.Username},
+	}
+	for _, label := range mr.Labels {
+		pr.Labels = append(pr.Labels, GithubLabel{Name: label})
+	}
+	if mr.Milestone != nil {
+		pr.Milestone.Title = mr.Milestone.Title
+		pr.Milestone.DueOn, _ = time.Parse("2006-01-02", mr.Milestone.DueDate)
+	}
+	pr.Head.Ref = mr.SourceBranch
+	pr.Head.Sha = mr.Sha
+	pr.Head.Repo.ID = mr.SourceProjectID
+	pr.Base.Ref = mr.TargetBranch
+	pr.Base.Repo.ID = mr.TargetProjectID
+	pr.Base.Sha = mr.DiffRefs.StartSha
+	return pr
+}
+
+// GitlabProvider speaks the GitLab v4 API of gitlab.com or of a self-hosted
+// instance set through PROVIDER_URL
+type GitlabProvider struct {
+	forgeClient
+	lock     sync.Mutex
+	diverged map[string]int
+	Config   *Config
+}
+
+func NewGitlabProvider(config *Config, client *http.Client) *GitlabProvider {
+	return &GitlabProvider{
+		forgeClient: forgeClient{Client: client, authHeader: "PRIVATE-TOKEN", authValue: config.GitlabToken},
+		diverged:    make(map[string]int),
+		Config:      config,
+	}
+}
+
+func (g *GitlabProvider) apiURL(format string, args ...interface{}) string {
+	project := url.PathEscape(g.Config.RepoAuthor + "/" + g.Config.RepoName)
+	return strings.TrimSuffix(withDefault(g.Config.ProviderURL, "https://gitlab.com"), "/") + "/api/v4/projects/" + project + fmt.Sprintf(format, args...)
+}
+
+// ListChangeRequests follows the X-Next-Page header until the last page
+func (g *GitlabProvider) ListChangeRequests() (PullRequestList, error) {
+	pullRequests := PullRequestList{}
+	for page := "1"; page != ""; {
+		mergeRequests := []GitlabMergeRequest{}
+		resp, err := g.getJSON(g.apiURL("/merge_requests?state=opened&per_page=100&page=%s", page), &mergeRequests)
+		if err != nil {
+			return nil, errors.Wrap(err, "listChangeRequests")
+		}
+		for _, mr := range mergeRequests {
+			pullRequests = append(pullRequests, mr.ToPullRequest())
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return pullRequests, nil
+}
+
+func (g *GitlabProvider) BranchHead(branch string) (string, error) {
+	b := GitlabBranch{}
+	if _, err := g.getJSON(g.apiURL("/repository/branches/%s", url.PathEscape(branch)), &b); err != nil {
+		return "", errors.Wrap(err, "branchHead")
+	}
+	return b.Commit.ID, nil
+}
+
+// PullRequestHead fetches the single merge request, which is the only way to
+// get its diverged_commits_count, and keeps it for CompareCommits
+func (g *GitlabProvider) PullRequestHead(pr GithubPullRequest) (string, error) {
+	mr := GitlabMergeRequest{}
+	if _, err := g.getJSON(g.apiURL("/merge_requests/%d?include_diverged_commits_count=true", pr.Number), &mr); err != nil {
+		return "", errors.Wrap(err, "pullRequestHead")
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.diverged[mr.Sha] = mr.DivergedCommitsCount
+	return mr.Sha, nil
+}
+
+func (g *GitlabProvider) countCommits(from string, to string) (int, error) {
+	compare := GitlabCompare{}
+	query := "/repository/compare?from=" + url.QueryEscape(from) + "&to=" + url.QueryEscape(to)
+	if _, err := g.getJSON(g.apiURL("%s", query), &compare); err != nil {
+		return 0, errors.Wrap(err, "compareCommits")
+	}
+	return len(compare.Commits), nil
+}
+
+// CompareCommits counts the head commits through the compare API. The base
+// commits are the diverged_commits_count of the merge request, counted from
+// the current tip of its target branch like the base the report compares
+// with, and are only counted through the compare API when it is not known.
+func (g *GitlabProvider) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	aheadBy, err := g.countCommits(baseSha, headSha)
+	if err != nil {
+		return nil, err
+	}
+	g.lock.Lock()
+	behindBy, found := g.diverged[headSha]
+	g.lock.Unlock()
+	if !found {
+		if behindBy, err = g.countCommits(headSha, baseSha); err != nil {
+			return nil, err
+		}
+	}
+	return &GithubCommitCompare{
+		Status:       compareStatus(aheadBy, behindBy),
+		AheadBy:      aheadBy,
+		BehindBy:     behindBy,
+		TotalCommits: aheadBy,
+	}, nil
+}
+
+func (g *GitlabProvider) AddLabels(number int, labels []string) error {
+	body := map[string]string{"add_labels": strings.Join(labels, ",")}
+	return g.write("PUT", g.apiURL("/merge_requests/%d", number), body)
+}
+
+func (g *GitlabProvider) AddComment(number int, comment string) error {
+	body := map[string]string{"body": comment}
+	return g.write("POST", g.apiURL("/merge_requests/%d/notes", number), body)
+}
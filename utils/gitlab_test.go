@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newForgeApp targets the repository group/project of the given provider on
+// a fake server
+func newForgeApp(provider string, url string) *AppMutex {
+	app := MakeAppWithDefaults()
+	app.Config = &Config{
+		Provider:       provider,
+		ProviderURL:    url,
+		GitlabToken:    "secret",
+		GiteaToken:     "secret",
+		BitbucketToken: "secret",
+		RepoAuthor:     "group",
+		RepoName:       "project",
+	}
+	return &app
+}
+
+// commitGraph answers the compare calls of the fakes: the number of commits
+// reachable from the first sha and not from the second
+type commitGraph map[[2]string]int
+
+func (g commitGraph) count(t *testing.T, from string, to string) int {
+	t.Helper()
+	count, found := g[[2]string{from, to}]
+	if !found {
+		t.Errorf("unexpected comparison of %s with %s", from, to)
+	}
+	return count
+}
+
+// compareAll compares every pull request through the provider, by number
+func compareAll(t *testing.T, provider Provider, pullRequests PullRequestList) map[int]*GithubCommitCompare {
+	t.Helper()
+	compares := make(map[int]*GithubCommitCompare)
+	for _, pr := range pullRequests {
+		headSha, err := provider.PullRequestHead(pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compares[pr.Number], err = provider.CompareCommits(pr.Base.Sha, headSha); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return compares
+}
+
+func gitlabMergeRequest(iid int, source string, target string, sha string, sourceProject int, diverged int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                     iid + 1000,
+		"iid":                    iid,
+		"title":                  "MR " + strconv.Itoa(iid),
+		"web_url":                "https://gitlab.example.com/group/project/-/merge_requests/" + strconv.Itoa(iid),
+		"created_at":             "2019-03-01T10:00:00Z",
+		"labels":                 []string{"backend"},
+		"source_branch":          source,
+		"target_branch":          target,
+		"sha":                    sha,
+		"author":                 map[string]string{"username": "alice"},
+		"diff_refs":              map[string]string{"base_sha": "old", "head_sha": sha, "start_sha": "old"},
+		"source_project_id":      sourceProject,
+		"target_project_id":      1,
+		"diverged_commits_count": diverged,
+	}
+}
+
+func TestGitlabProviderReport(t *testing.T) {
+	// the commits ahead of the target tips, the commits behind being the
+	// diverged_commits_count of the merge requests
+	graph := commitGraph{
+		// !1 is 2 ahead of master, which moved 3 commits since
+		{"feature", "master-tip"}: 2,
+		// !2 is stacked on !1
+		{"stacked", "feature"}: 1,
+		// !3 comes from a fork and has a master branch of its own
+		{"fork-master", "master-tip"}: 1,
+	}
+	mergeRequests := map[string]map[string]interface{}{
+		"1": gitlabMergeRequest(1, "feature", "master", "feature", 1, 3),
+		"2": gitlabMergeRequest(2, "stacked", "feature", "stacked", 1, 0),
+		"3": gitlabMergeRequest(3, "master", "master", "fork-master", 2, 0),
+	}
+	branchCalls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query := r.URL.Query()
+		const prefix = "/api/v4/projects/group%2Fproject"
+		switch path := r.URL.EscapedPath(); {
+		case path == prefix+"/merge_requests":
+			if query.Get("state") != "opened" {
+				t.Errorf("merge requests listed with state %q", query.Get("state"))
+			}
+			if query.Get("page") == "1" {
+				w.Header().Set("X-Next-Page", "2")
+				json.NewEncoder(w).Encode([]interface{}{mergeRequests["1"], mergeRequests["2"]})
+				return
+			}
+			json.NewEncoder(w).Encode([]interface{}{mergeRequests["3"]})
+		case strings.HasPrefix(path, prefix+"/merge_requests/"):
+			if query.Get("include_diverged_commits_count") != "true" {
+				t.Errorf("merge request fetched without its diverged commits count")
+			}
+			json.NewEncoder(w).Encode(mergeRequests[strings.TrimPrefix(path, prefix+"/merge_requests/")])
+		case path == prefix+"/repository/branches/master":
+			branchCalls++
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "master", "commit": map[string]string{"id": "master-tip"}})
+		case path == prefix+"/repository/branches/feature":
+			branchCalls++
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "feature", "commit": map[string]string{"id": "feature"}})
+		case path == prefix+"/repository/compare":
+			commits := []map[string]string{}
+			for i := 0; i < graph.count(t, query.Get("to"), query.Get("from")); i++ {
+				commits = append(commits, map[string]string{"id": strconv.Itoa(i)})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"commits": commits})
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	app := newForgeApp("gitlab", server.URL)
+	provider, err := NewProvider(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullRequests, backend, err := ReportSource(app, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pullRequests) != 3 {
+		t.Fatalf("%d merge requests, want 3", len(pullRequests))
+	}
+	if branchCalls != 2 {
+		t.Errorf("%d branch calls, want one per target branch", branchCalls)
+	}
+	report, err := BuildReport(app.Config.Repo(), pullRequests, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]struct {
+		aheadBy, behindBy, stackParent int
+		fork                           bool
+	}{
+		1: {2, 3, 0, false},
+		2: {1, 0, 1, false},
+		3: {1, 0, 0, true},
+	}
+	for _, row := range report.Rows {
+		w := want[row.Number]
+		if row.AheadBy != w.aheadBy || row.BehindBy != w.behindBy || row.StackParent != w.stackParent || row.Fork != w.fork {
+			t.Errorf("!%d: %d ahead, %d behind, on !%d, fork %t, want %d ahead, %d behind, on !%d, fork %t",
+				row.Number, row.AheadBy, row.BehindBy, row.StackParent, row.Fork, w.aheadBy, w.behindBy, w.stackParent, w.fork)
+		}
+	}
+	if row := report.Rows[0]; row.Author != "alice" || len(row.Labels) != 1 || row.Labels[0] != "backend" {
+		t.Errorf("!1 mapped as %+v", row)
+	}
+}
+
+func TestGitlabProviderWrites(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath()+" "+body["add_labels"]+body["body"])
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	provider := NewGitlabProvider(newForgeApp("gitlab", server.URL).Config, http.DefaultClient)
+	if err := provider.AddLabels(4, []string{"outdated", "rebase"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.AddComment(4, "Please rebase"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"PUT /api/v4/projects/group%2Fproject/merge_requests/4 outdated,rebase",
+		"POST /api/v4/projects/group%2Fproject/merge_requests/4/notes Please rebase",
+	}
+	if len(requests) != 2 || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("requests %q, want %q", requests, want)
+	}
+}
@@ -0,0 +1,372 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// https://developer.github.com/v4/object/ratelimit/
+type GraphqlRateLimit struct {
+	Cost      int       `json:"cost"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+type GraphqlError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+type GraphqlPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// https://developer.github.com/v4/object/pullrequest/
+type GraphqlPullRequest struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+	IsDraft   bool      `json:"isDraft"`
+	Mergeable string    `json:"mergeable"`
+	Author    struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	Labels    GraphqlLabels `json:"labels"`
+	Milestone *struct {
+		Title string     `json:"title"`
+		DueOn *time.Time `json:"dueOn"`
+	} `json:"milestone"`
+	BaseRefName    string `json:"baseRefName"`
+	BaseRefOid     string `json:"baseRefOid"`
+	HeadRefName    string `json:"headRefName"`
+	HeadRefOid     string `json:"headRefOid"`
+	HeadRepository struct {
+		NameWithOwner string `json:"nameWithOwner"`
+	} `json:"headRepository"`
+	BaseRepository struct {
+		NameWithOwner string `json:"nameWithOwner"`
+	} `json:"baseRepository"`
+}
+
+// https://developer.github.com/v4/object/labelconnection/
+type GraphqlLabels struct {
+	PageInfo GraphqlPageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Name string `json:"name"`
+	} `json:"nodes"`
+}
+
+type GraphqlTarget struct {
+	Oid string `json:"oid"`
+}
+
+// https://developer.github.com/v4/object/comparison/
+type GraphqlComparison struct {
+	AheadBy    int           `json:"aheadBy"`
+	BehindBy   int           `json:"behindBy"`
+	Status     string        `json:"status"`
+	BaseTarget GraphqlTarget `json:"baseTarget"`
+	HeadTarget GraphqlTarget `json:"headTarget"`
+}
+
+const graphqlPullRequestsQuery = `query($owner: String!, $name: String!, $first: Int!, $after: String) {
+  rateLimit { cost remaining resetAt }
+  repository(owner: $owner, name: $name) {
+    pullRequests(states: OPEN, first: $first, after: $after) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        number title url createdAt isDraft mergeable
+        author { login }
+        labels(first: 20) { pageInfo { hasNextPage endCursor } nodes { name } }
+        milestone { title dueOn }
+        baseRefName baseRefOid headRefName headRefOid
+        headRepository { nameWithOwner }
+        baseRepository { nameWithOwner }
+      }
+    }
+  }
+}`
+
+// graphqlLabelsQuery pages through the labels of a pull request with more
+// labels than the first ones listed with it
+const graphqlLabelsQuery = `query($owner: String!, $name: String!, $number: Int!, $after: String) {
+  rateLimit { cost remaining resetAt }
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      labels(first: 100, after: $after) { pageInfo { hasNextPage endCursor } nodes { name } }
+    }
+  }
+}`
+
+func (a *AppMutex) ApiGraphql(query string, variables map[string]interface{}) *http.Request {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		Fatal("apiGraphql", "error", err)
+	}
+	req, err := http.NewRequest("POST", a.Config.GraphqlURL, bytes.NewReader(body))
+	if err != nil {
+		Fatal("apiGraphql", "error", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// GraphqlQuery decodes the data of the response into result and returns the
+// rate limit status reported alongside it
+func (a *AppMutex) GraphqlQuery(query string, variables map[string]interface{}, result interface{}) (*GraphqlRateLimit, error) {
+	resp, err := a.doRequest(a.ApiGraphql(query, variables))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("graphqlQuery: unexpected status %s", resp.Status)
+	}
+
+	payload := struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []GraphqlError  `json:"errors"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Errors) > 0 {
+		return nil, errors.New("graphqlQuery: " + payload.Errors[0].Message)
+	}
+	rateLimit := struct {
+		RateLimit GraphqlRateLimit `json:"rateLimit"`
+	}{}
+	if err := json.Unmarshal(payload.Data, &rateLimit); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(payload.Data, result); err != nil {
+		return nil, err
+	}
+	return &rateLimit.RateLimit, nil
+}
+
+// waitForRateLimit sleeps until the reset when the remaining points would not
+// cover another query as expensive as the last one
+func waitForRateLimit(rateLimit *GraphqlRateLimit) {
+	if rateLimit == nil || rateLimit.Remaining >= rateLimit.Cost {
+		return
+	}
+	wait := time.Until(rateLimit.ResetAt)
+	if wait > 0 {
+		slog.Info("GraphQL rate limit exhausted, waiting for the reset", "wait", wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+}
+
+func (a *AppMutex) RetrievePullRequestsGraphql() ([]GraphqlPullRequest, error) {
+	pullRequests := []GraphqlPullRequest{}
+	variables := map[string]interface{}{
+		"owner": a.Config.RepoAuthor,
+		"name":  a.Config.RepoName,
+		"first": a.Config.GraphqlBatchSize(),
+	}
+	for {
+		page := struct {
+			Repository struct {
+				PullRequests struct {
+					PageInfo GraphqlPageInfo      `json:"pageInfo"`
+					Nodes    []GraphqlPullRequest `json:"nodes"`
+				} `json:"pullRequests"`
+			} `json:"repository"`
+		}{}
+		rateLimit, err := a.GraphqlQuery(graphqlPullRequestsQuery, variables, &page)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrievePullRequestsGraphql")
+		}
+		for i := range page.Repository.PullRequests.Nodes {
+			if err := a.retrieveLabelsGraphql(&page.Repository.PullRequests.Nodes[i]); err != nil {
+				return nil, err
+			}
+		}
+		pullRequests = append(pullRequests, page.Repository.PullRequests.Nodes...)
+		if !page.Repository.PullRequests.PageInfo.HasNextPage {
+			return pullRequests, nil
+		}
+		variables["after"] = page.Repository.PullRequests.PageInfo.EndCursor
+		waitForRateLimit(rateLimit)
+	}
+}
+
+// retrieveLabelsGraphql appends the labels of the pull request beyond the
+// first ones listed with it
+func (a *AppMutex) retrieveLabelsGraphql(pr *GraphqlPullRequest) error {
+	for pr.Labels.PageInfo.HasNextPage {
+		variables := map[string]interface{}{
+			"owner":  a.Config.RepoAuthor,
+			"name":   a.Config.RepoName,
+			"number": pr.Number,
+			"after":  pr.Labels.PageInfo.EndCursor,
+		}
+		page := struct {
+			Repository struct {
+				PullRequest struct {
+					Labels GraphqlLabels `json:"labels"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}{}
+		rateLimit, err := a.GraphqlQuery(graphqlLabelsQuery, variables, &page)
+		if err != nil {
+			return errors.Wrapf(err, "retrieveLabelsGraphql #%d", pr.Number)
+		}
+		labels := page.Repository.PullRequest.Labels
+		pr.Labels.Nodes = append(pr.Labels.Nodes, labels.Nodes...)
+		pr.Labels.PageInfo = labels.PageInfo
+		waitForRateLimit(rateLimit)
+	}
+	return nil
+}
+
+// CompareGraphql compares the tip of every same-repository pull request head
+// with the tip of its base branch, batching up to GraphqlBatchSize
+// comparisons per query, each one returning the commits it compared. Pull
+// requests from forks are left out, their head ref cannot be resolved in the
+// base repository.
+func (a *AppMutex) CompareGraphql(pullRequests []GraphqlPullRequest) (map[int]GraphqlComparison, error) {
+	sameRepo := []GraphqlPullRequest{}
+	fullName := a.Config.RepoAuthor + "/" + a.Config.RepoName
+	for _, pr := range pullRequests {
+		if strings.EqualFold(pr.HeadRepository.NameWithOwner, fullName) {
+			sameRepo = append(sameRepo, pr)
+		}
+	}
+
+	comparisons := make(map[int]GraphqlComparison)
+	pageSize := a.Config.GraphqlBatchSize()
+	for start := 0; start < len(sameRepo); start += pageSize {
+		end := start + pageSize
+		if end > len(sameRepo) {
+			end = len(sameRepo)
+		}
+		batch := sameRepo[start:end]
+
+		var query bytes.Buffer
+		query.WriteString("query($owner: String!, $name: String!) {\n  rateLimit { cost remaining resetAt }\n  repository(owner: $owner, name: $name) {\n")
+		for _, pr := range batch {
+			base, _ := json.Marshal("refs/heads/" + pr.BaseRefName)
+			head, _ := json.Marshal(pr.HeadRefName)
+			fmt.Fprintf(&query, "    pr%d: ref(qualifiedName: %s) { compare(headRef: %s) { aheadBy behindBy status baseTarget { oid } headTarget { oid } } }\n", pr.Number, base, head)
+		}
+		query.WriteString("  }\n}")
+
+		result := struct {
+			Repository map[string]*struct {
+				Compare *GraphqlComparison `json:"compare"`
+			} `json:"repository"`
+		}{}
+		variables := map[string]interface{}{"owner": a.Config.RepoAuthor, "name": a.Config.RepoName}
+		rateLimit, err := a.GraphqlQuery(query.String(), variables, &result)
+		if err != nil {
+			return nil, errors.Wrap(err, "compareGraphql")
+		}
+		for _, pr := range batch {
+			ref := result.Repository["pr"+strconv.Itoa(pr.Number)]
+			if ref != nil && ref.Compare != nil {
+				comparisons[pr.Number] = *ref.Compare
+			}
+		}
+		waitForRateLimit(rateLimit)
+	}
+	return comparisons, nil
+}
+
+// ToPullRequest maps the GraphQL node onto the REST model the report uses
+func (g GraphqlPullRequest) ToPullRequest() GithubPullRequest {
+	pr := GithubPullRequest{
+		Number:    g.Number,
+		Title:     g.Title,
+		HTMLURL:   g.URL,
+		State:     "open",
+		CreatedAt: g.CreatedAt,
+		User:      GithubUser{Login: g.Author.Login},
+		Draft:     g.IsDraft,
+	}
+	for _, label := range g.Labels.Nodes {
+		pr.Labels = append(pr.Labels, GithubLabel{Name: label.Name})
+	}
+	if g.Milestone != nil {
+		pr.Milestone.Title = g.Milestone.Title
+		if g.Milestone.DueOn != nil {
+			pr.Milestone.DueOn = *g.Milestone.DueOn
+		}
+	}
+	switch g.Mergeable {
+	case "MERGEABLE":
+		mergeable := true
+		pr.Mergeable = &mergeable
+	case "CONFLICTING":
+		mergeable := false
+		pr.Mergeable = &mergeable
+	}
+	pr.Head.Ref = g.HeadRefName
+	pr.Head.Sha = g.HeadRefOid
+	pr.Head.Repo.FullName = g.HeadRepository.NameWithOwner
+	pr.Base.Ref = g.BaseRefName
+	pr.Base.Sha = g.BaseRefOid
+	pr.Base.Repo.FullName = g.BaseRepository.NameWithOwner
+	return pr
+}
+
+func (c GraphqlComparison) ToCommitCompare() GithubCommitCompare {
+	return GithubCommitCompare{
+		Status:       strings.ToLower(c.Status),
+		AheadBy:      c.AheadBy,
+		BehindBy:     c.BehindBy,
+		TotalCommits: c.AheadBy,
+	}
+}
+
+// GraphqlBackend serves the comparisons fetched in bulk, keyed by the commits
+// they compared, and falls back to the REST compare endpoint for the pull
+// requests it could not resolve
+type GraphqlBackend struct {
+	App         *AppMutex
+	heads       map[int]string
+	comparisons map[string]GithubCommitCompare
+}
+
+// NewGraphqlBackend also caches the base branch tips the comparisons were
+// made with, which ReportSource then sets as the base of the pull requests
+func NewGraphqlBackend(app *AppMutex, pullRequests []GraphqlPullRequest, comparisons map[int]GraphqlComparison) *GraphqlBackend {
+	backend := GraphqlBackend{App: app, heads: make(map[int]string), comparisons: make(map[string]GithubCommitCompare)}
+	app.lock.Lock()
+	defer app.lock.Unlock()
+	for _, pr := range pullRequests {
+		if c, found := comparisons[pr.Number]; found {
+			backend.heads[pr.Number] = c.HeadTarget.Oid
+			backend.comparisons[c.BaseTarget.Oid+"..."+c.HeadTarget.Oid] = c.ToCommitCompare()
+			app.BaseBranchMap[pr.BaseRefName] = c.BaseTarget.Oid
+		}
+	}
+	return &backend
+}
+
+// PullRequestHead returns the head commit the comparison was made with,
+// which may be newer than the one listed
+func (g *GraphqlBackend) PullRequestHead(pr GithubPullRequest) (string, error) {
+	if head, found := g.heads[pr.Number]; found {
+		return head, nil
+	}
+	return pr.Head.Sha, nil
+}
+
+func (g *GraphqlBackend) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	if c, found := g.comparisons[baseSha+"..."+headSha]; found {
+		return &c, nil
+	}
+	return g.App.CompareCommits(baseSha, headSha)
+}
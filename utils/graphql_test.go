@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGraphql serves the open pull requests first at a time, and the
+// comparisons of the aliased refs, counting the queries of each kind
+type fakeGraphql struct {
+	t            *testing.T
+	pullRequests []map[string]interface{}
+	comparisons  map[int]GraphqlComparison
+	listQueries  int
+	labelQueries int
+	batches      [][]int
+}
+
+var graphqlAlias = regexp.MustCompile(`pr(\d+): ref`)
+
+func (f *fakeGraphql) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body := struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		f.t.Errorf("invalid GraphQL request: %v", err)
+		return
+	}
+	if body.Variables["owner"] != "octo" || body.Variables["name"] != "repo" {
+		f.t.Errorf("unexpected repository %v/%v", body.Variables["owner"], body.Variables["name"])
+	}
+	data := map[string]interface{}{
+		"rateLimit": map[string]interface{}{"cost": 1, "remaining": 4999, "resetAt": time.Now().Add(time.Hour)},
+	}
+
+	if strings.Contains(body.Query, "pullRequests(") {
+		f.listQueries++
+		first := int(body.Variables["first"].(float64))
+		if first < 1 || first > 100 {
+			f.t.Errorf("first = %d, GitHub only accepts 1 to 100", first)
+			http.Error(w, "invalid first", http.StatusBadRequest)
+			return
+		}
+		start := 0
+		if after, found := body.Variables["after"]; found {
+			start, _ = strconv.Atoi(after.(string))
+		}
+		end := start + first
+		if end > len(f.pullRequests) {
+			end = len(f.pullRequests)
+		}
+		data["repository"] = map[string]interface{}{
+			"pullRequests": map[string]interface{}{
+				"pageInfo": map[string]interface{}{"hasNextPage": end < len(f.pullRequests), "endCursor": strconv.Itoa(end)},
+				"nodes":    f.pullRequests[start:end],
+			},
+		}
+	} else if strings.Contains(body.Query, "pullRequest(") {
+		// a single page of labels after the listed ones
+		f.labelQueries++
+		if body.Variables["after"] != "20" {
+			f.t.Errorf("labels paged after %v", body.Variables["after"])
+		}
+		data["repository"] = map[string]interface{}{
+			"pullRequest": map[string]interface{}{
+				"labels": map[string]interface{}{
+					"pageInfo": map[string]interface{}{"hasNextPage": false},
+					"nodes":    []map[string]string{{"name": "backend"}},
+				},
+			},
+		}
+	} else {
+		repository := map[string]interface{}{}
+		batch := []int{}
+		for _, match := range graphqlAlias.FindAllStringSubmatch(body.Query, -1) {
+			number, _ := strconv.Atoi(match[1])
+			batch = append(batch, number)
+			if c, found := f.comparisons[number]; found {
+				repository["pr"+match[1]] = map[string]interface{}{"compare": c}
+			} else {
+				repository["pr"+match[1]] = nil
+			}
+		}
+		f.batches = append(f.batches, batch)
+		data["repository"] = repository
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func graphqlNode(number int, headRepo string) map[string]interface{} {
+	return map[string]interface{}{
+		"number":         number,
+		"title":          fmt.Sprintf("PR %d", number),
+		"url":            fmt.Sprintf("https://github.com/octo/repo/pull/%d", number),
+		"createdAt":      "2019-03-01T10:00:00Z",
+		"mergeable":      "MERGEABLE",
+		"author":         map[string]string{"login": "alice"},
+		"labels":         map[string]interface{}{"nodes": []map[string]string{{"name": "bug"}}},
+		"baseRefName":    "master",
+		"baseRefOid":     "base",
+		"headRefName":    fmt.Sprintf("feature-%d", number),
+		"headRefOid":     fmt.Sprintf("head%d", number),
+		"headRepository": map[string]string{"nameWithOwner": headRepo},
+		"baseRepository": map[string]string{"nameWithOwner": "octo/repo"},
+	}
+}
+
+func newGraphqlApp(url string, pageSize int) *AppMutex {
+	app := MakeAppWithDefaults()
+	app.Config = &Config{
+		Provider:        "github",
+		DataSource:      "graphql",
+		OauthToken:      "secret",
+		RepoAuthor:      "octo",
+		RepoName:        "repo",
+		GraphqlURL:      url,
+		GraphqlPageSize: pageSize,
+	}
+	return &app
+}
+
+func TestGraphqlReportSource(t *testing.T) {
+	node := graphqlNode(1, "octo/repo")
+	node["labels"] = map[string]interface{}{
+		"pageInfo": map[string]interface{}{"hasNextPage": true, "endCursor": "20"},
+		"nodes":    []map[string]string{{"name": "bug"}},
+	}
+	// the base branch and the head of #3 moved since they were listed
+	fake := &fakeGraphql{
+		t:            t,
+		pullRequests: []map[string]interface{}{node, graphqlNode(2, "octo/repo"), graphqlNode(3, "octo/repo")},
+		comparisons: map[int]GraphqlComparison{
+			1: {AheadBy: 2, BehindBy: 0, Status: "AHEAD", BaseTarget: GraphqlTarget{"tip"}, HeadTarget: GraphqlTarget{"head1"}},
+			2: {AheadBy: 1, BehindBy: 3, Status: "DIVERGED", BaseTarget: GraphqlTarget{"tip"}, HeadTarget: GraphqlTarget{"head2"}},
+			3: {AheadBy: 0, BehindBy: 1, Status: "BEHIND", BaseTarget: GraphqlTarget{"tip"}, HeadTarget: GraphqlTarget{"head3-pushed"}},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// a page size of 0 is clamped to 1 instead of never advancing
+	app := newGraphqlApp(server.URL, 0)
+	pullRequests, backend, err := ReportSource(app, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pullRequests) != 3 || fake.listQueries != 3 || fake.labelQueries != 1 {
+		t.Fatalf("%d pull requests in %d queries and %d label queries, want 3 in 3 and 1", len(pullRequests), fake.listQueries, fake.labelQueries)
+	}
+	if len(fake.batches) != 3 {
+		t.Errorf("%d comparison queries, want one per pull request", len(fake.batches))
+	}
+
+	// the comparisons are served without any REST call, which would fail
+	report, err := BuildReport(app.Config.Repo(), pullRequests, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]ReportRow{
+		1: {AheadBy: 2, BehindBy: 0, Status: "ahead", HeadSha: "head1"},
+		2: {AheadBy: 1, BehindBy: 3, Status: "diverged", HeadSha: "head2"},
+		3: {AheadBy: 0, BehindBy: 1, Status: "behind", HeadSha: "head3-pushed"},
+	}
+	for _, row := range report.Rows {
+		w := want[row.Number]
+		if row.AheadBy != w.AheadBy || row.BehindBy != w.BehindBy || row.Status != w.Status || row.HeadSha != w.HeadSha || row.BaseSha != "tip" {
+			t.Errorf("#%d compared %s with %s as %d ahead, %d behind, %s, want %s with tip as %d ahead, %d behind, %s",
+				row.Number, row.HeadSha, row.BaseSha, row.AheadBy, row.BehindBy, row.Status, w.HeadSha, w.AheadBy, w.BehindBy, w.Status)
+		}
+		if row.Author != "alice" || row.BaseRef != "master" || row.Fork {
+			t.Errorf("#%d mapped as %+v", row.Number, row)
+		}
+	}
+	if labels := report.Rows[0].Labels; strings.Join(labels, ",") != "bug,backend" {
+		t.Errorf("#1 labelled %v, want bug,backend", labels)
+	}
+}
+
+func TestCompareGraphqlBatches(t *testing.T) {
+	fake := &fakeGraphql{
+		t: t,
+		comparisons: map[int]GraphqlComparison{
+			1: {AheadBy: 1, Status: "AHEAD"},
+			3: {BehindBy: 2, Status: "BEHIND"},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	nodes := []GraphqlPullRequest{}
+	for _, pr := range []struct {
+		number   int
+		headRepo string
+	}{{1, "octo/repo"}, {2, "fork/repo"}, {3, "Octo/Repo"}, {4, "octo/repo"}} {
+		node := GraphqlPullRequest{Number: pr.number, BaseRefName: "master", HeadRefName: "feature"}
+		node.HeadRepository.NameWithOwner = pr.headRepo
+		nodes = append(nodes, node)
+	}
+	comparisons, err := newGraphqlApp(server.URL, 2).CompareGraphql(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fork is left out, the other three go in batches of two
+	if fmt.Sprint(fake.batches) != "[[1 3] [4]]" {
+		t.Errorf("batches %v, want [[1 3] [4]]", fake.batches)
+	}
+	if len(comparisons) != 2 || comparisons[1].AheadBy != 1 || comparisons[3].BehindBy != 2 {
+		t.Errorf("comparisons %+v", comparisons)
+	}
+}
+
+func TestGraphqlQueryErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": []GraphqlError{{Message: "Could not resolve to a Repository", Type: "NOT_FOUND"}},
+		})
+	}))
+	defer server.Close()
+
+	_, err := newGraphqlApp(server.URL, 100).RetrievePullRequestsGraphql()
+	if err == nil || !strings.Contains(err.Error(), "Could not resolve to a Repository") {
+		t.Errorf("error %v, want the GraphQL error", err)
+	}
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// HistoryEntry is the state of one pull request at the time of a run
+type HistoryEntry struct {
+	Number   int    `json:"number"`
+	Author   string `json:"author"`
+	BaseRef  string `json:"base_ref"`
+	Status   string `json:"status"`
+	AheadBy  int    `json:"ahead_by"`
+	BehindBy int    `json:"behind_by"`
+}
+
+// HistoryRun holds every pull request of a repository for one run, so that
+// the runs without any open pull request are recorded as well
+type HistoryRun struct {
+	Repo         string         `json:"repo"`
+	RecordedAt   time.Time      `json:"recorded_at"`
+	PullRequests []HistoryEntry `json:"pull_requests"`
+}
+
+// HistoryStore records the runs in a BoltDB file. Every repository has a
+// bucket holding the timestamps of its runs, so that the runs without any
+// open pull request are kept as well, and the state of every pull request
+// keyed by its number and the timestamp of the run.
+type HistoryStore struct {
+	Path string
+}
+
+var (
+	historyRunsBucket         = []byte("runs")
+	historyPullRequestsBucket = []byte("pull_requests")
+)
+
+func historyTimestamp(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// historyKey sorts the states of a pull request chronologically after its
+// number
+func historyKey(number int, t time.Time) []byte {
+	key := make([]byte, 8, 16)
+	binary.BigEndian.PutUint64(key, uint64(number))
+	return append(key, historyTimestamp(t)...)
+}
+
+func (h HistoryStore) open(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(h.Path, 0644, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+}
+
+func NewHistoryRun(report *Report) HistoryRun {
+	run := HistoryRun{Repo: report.Repo, RecordedAt: report.GeneratedAt, PullRequests: []HistoryEntry{}}
+	for _, row := range report.Rows {
+		run.PullRequests = append(run.PullRequests, HistoryEntry{
+			Number:   row.Number,
+			Author:   row.Author,
+			BaseRef:  row.BaseRef,
+			Status:   row.Status,
+			AheadBy:  row.AheadBy,
+			BehindBy: row.BehindBy,
+		})
+	}
+	return run
+}
+
+func (h HistoryStore) Append(report *Report) error {
+	run := NewHistoryRun(report)
+	db, err := h.open(false)
+	if err != nil {
+		return errors.Wrap(err, "historyStore: "+h.Path)
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		repo, err := tx.CreateBucketIfNotExists([]byte(run.Repo))
+		if err != nil {
+			return err
+		}
+		runs, err := repo.CreateBucketIfNotExists(historyRunsBucket)
+		if err != nil {
+			return err
+		}
+		pullRequests, err := repo.CreateBucketIfNotExists(historyPullRequestsBucket)
+		if err != nil {
+			return err
+		}
+		if err := runs.Put(historyTimestamp(run.RecordedAt), []byte(strconv.Itoa(len(run.PullRequests)))); err != nil {
+			return err
+		}
+		for _, entry := range run.PullRequests {
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := pullRequests.Put(historyKey(entry.Number, run.RecordedAt), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Runs returns the runs of the repository in chronological order
+func (h HistoryStore) Runs(repo string) ([]HistoryRun, error) {
+	runs := []HistoryRun{}
+	if _, err := os.Stat(h.Path); os.IsNotExist(err) {
+		return runs, nil
+	}
+	db, err := h.open(true)
+	if err != nil {
+		return nil, errors.Wrap(err, "historyStore: "+h.Path)
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(repo))
+		if bucket == nil {
+			return nil
+		}
+		index := make(map[string]int)
+		err := bucket.Bucket(historyRunsBucket).ForEach(func(k, v []byte) error {
+			index[string(k)] = len(runs)
+			recordedAt := time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+			runs = append(runs, HistoryRun{Repo: repo, RecordedAt: recordedAt, PullRequests: []HistoryEntry{}})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Bucket(historyPullRequestsBucket).ForEach(func(k, v []byte) error {
+			i, found := index[string(k[8:])]
+			if !found {
+				return nil
+			}
+			entry := HistoryEntry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			runs[i].PullRequests = append(runs[i].PullRequests, entry)
+			return nil
+		})
+	})
+	return runs, err
+}
+
+// TrendRow aggregates the runs of an ISO week
+type TrendRow struct {
+	Week      string  `json:"week"`
+	Runs      int     `json:"runs"`
+	OpenPRs   float64 `json:"open_prs"`
+	AvgBehind float64 `json:"avg_behind"`
+	MaxBehind int     `json:"max_behind"`
+}
+
+// WeeklyTrends averages the open pull requests per run, and the commits
+// behind over every pull request seen during the week
+func WeeklyTrends(runs []HistoryRun) []TrendRow {
+	trends := []TrendRow{}
+	index := make(map[string]int)
+	totals := make(map[string][2]int)
+	for _, run := range runs {
+		year, week := run.RecordedAt.ISOWeek()
+		key := fmt.Sprintf("%d-W%02d", year, week)
+		i, found := index[key]
+		if !found {
+			i = len(trends)
+			index[key] = i
+			trends = append(trends, TrendRow{Week: key})
+		}
+		trends[i].Runs++
+		total := totals[key]
+		for _, pr := range run.PullRequests {
+			total[0]++
+			total[1] += pr.BehindBy
+			if pr.BehindBy > trends[i].MaxBehind {
+				trends[i].MaxBehind = pr.BehindBy
+			}
+		}
+		totals[key] = total
+	}
+	for i, trend := range trends {
+		total := totals[trend.Week]
+		trends[i].OpenPRs = float64(total[0]) / float64(trend.Runs)
+		if total[0] > 0 {
+			trends[i].AvgBehind = float64(total[1]) / float64(total[0])
+		}
+	}
+	return trends
+}
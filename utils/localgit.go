@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CommitBackend resolves the head of a pull request and compares it with its
+// base, either through the Github API or through a local clone
+type CommitBackend interface {
+	PullRequestHead(pr GithubPullRequest) (string, error)
+	CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error)
+}
+
+func (a *AppMutex) PullRequestHead(pr GithubPullRequest) (string, error) {
+	return a.GetLastCommit(pr.Head.Ref)
+}
+
+// LocalGit works against a local clone or bare mirror of the repository,
+// so that comparisons do not cost any API call
+type LocalGit struct {
+	Dir    string
+	Remote string
+	// PullRefs is the pattern under which the forge exposes the pull request
+	// heads, e.g. refs/pull/*/head on Github
+	PullRefs string
+}
+
+func NewLocalGit(dir string, remote string) *LocalGit {
+	return &LocalGit{Dir: dir, Remote: withDefault(remote, "origin"), PullRefs: "refs/pull/*/head"}
+}
+
+func (g *LocalGit) git(args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", g.Dir}, args...)...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", errors.Wrapf(err, "git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", errors.Wrapf(err, "git %s", strings.Join(args, " "))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// fetchLock serialises the fetches, the refresh and every webhook event
+// fetching in the same clones concurrently otherwise
+var fetchLock sync.Mutex
+
+// FetchPullRequests updates the remote branches and the PullRefs the forge
+// exposes for every pull request, forks included
+func (g *LocalGit) FetchPullRequests() error {
+	fetchLock.Lock()
+	defer fetchLock.Unlock()
+	_, err := g.git("fetch", "--prune", "--quiet", g.Remote,
+		"+refs/heads/*:refs/remotes/"+g.Remote+"/*",
+		"+"+g.PullRefs+":"+g.PullRefs,
+	)
+	return err
+}
+
+// ResolveRef looks the name up as a remote branch first, then as a local
+// branch (bare mirrors) and finally as any revision git understands
+func (g *LocalGit) ResolveRef(name string) (string, error) {
+	candidates := []string{"refs/remotes/" + g.Remote + "/" + name, "refs/heads/" + name, name}
+	var err error
+	for _, ref := range candidates {
+		var sha string
+		if sha, err = g.git("rev-parse", "--verify", "--quiet", ref+"^{commit}"); err == nil {
+			return sha, nil
+		}
+	}
+	return "", errors.Wrap(err, "resolveRef "+name)
+}
+
+func (g *LocalGit) PullRequestHead(pr GithubPullRequest) (string, error) {
+	return g.ResolveRef(strings.Replace(g.PullRefs, "*", strconv.Itoa(pr.Number), 1))
+}
+
+// CompareCommits mimics https://developer.github.com/v3/repos/commits/#compare-two-commits
+func (g *LocalGit) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	base, err := g.ResolveRef(baseSha)
+	if err != nil {
+		return nil, err
+	}
+	head, err := g.ResolveRef(headSha)
+	if err != nil {
+		return nil, err
+	}
+	mergeBase, err := g.git("merge-base", base, head)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := g.git("rev-list", "--left-right", "--count", base+"..."+head)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(counts)
+	if len(fields) != 2 {
+		return nil, errors.New("compareCommits: unexpected rev-list output " + counts)
+	}
+	behindBy, _ := strconv.Atoi(fields[0])
+	aheadBy, _ := strconv.Atoi(fields[1])
+
+	compare := GithubCommitCompare{
+		AheadBy:      aheadBy,
+		BehindBy:     behindBy,
+		TotalCommits: aheadBy,
+		Status:       compareStatus(aheadBy, behindBy),
+	}
+	compare.BaseCommit.Sha = base
+	compare.MergeBaseCommit.Sha = mergeBase
+	return &compare, nil
+}
+
+func compareStatus(aheadBy int, behindBy int) string {
+	switch {
+	case aheadBy == 0 && behindBy == 0:
+		return "identical"
+	case behindBy == 0:
+		return "ahead"
+	case aheadBy == 0:
+		return "behind"
+	default:
+		return "diverged"
+	}
+}
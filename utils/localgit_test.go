@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newGitRemote creates a bare repository with a master one commit ahead of
+// the base of the feature branch, itself two commits ahead and exposed as
+// the head of pull request #1, and returns its path with the feature head
+func newGitRemote(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(env, "Test")
+	}
+	for _, env := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(env, "test@example.com")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	remote := filepath.Join(root, "remote.git")
+	runGit(t, root, "init", "--quiet", "--bare", remote)
+	runGit(t, root, "init", "--quiet", "-b", "master", work)
+	runGit(t, work, "commit", "--quiet", "--allow-empty", "-m", "initial")
+	runGit(t, work, "checkout", "--quiet", "-b", "feature")
+	runGit(t, work, "commit", "--quiet", "--allow-empty", "-m", "feature 1")
+	runGit(t, work, "commit", "--quiet", "--allow-empty", "-m", "feature 2")
+	feature := runGit(t, work, "rev-parse", "HEAD")
+	runGit(t, work, "checkout", "--quiet", "master")
+	runGit(t, work, "commit", "--quiet", "--allow-empty", "-m", "master 1")
+	runGit(t, work, "push", "--quiet", remote, "master", "feature", "feature:refs/pull/1/head")
+	return remote, feature
+}
+
+func TestLocalGitCompareCommits(t *testing.T) {
+	remote, feature := newGitRemote(t)
+	mirror := filepath.Join(t.TempDir(), "mirror.git")
+	runGit(t, filepath.Dir(mirror), "init", "--quiet", "--bare", mirror)
+	runGit(t, mirror, "remote", "add", "origin", remote)
+
+	g := NewLocalGit(mirror, "")
+	if err := g.FetchPullRequests(); err != nil {
+		t.Fatal(err)
+	}
+	head, err := g.PullRequestHead(GithubPullRequest{Number: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != feature {
+		t.Errorf("PullRequestHead = %s, want %s", head, feature)
+	}
+
+	compare, err := g.CompareCommits("master", head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compare.AheadBy != 2 || compare.BehindBy != 1 || compare.Status != "diverged" {
+		t.Errorf("CompareCommits = %d ahead, %d behind, %s, want 2 ahead, 1 behind, diverged", compare.AheadBy, compare.BehindBy, compare.Status)
+	}
+	master, err := g.ResolveRef("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compare.BaseCommit.Sha != master || compare.MergeBaseCommit.Sha != runGit(t, mirror, "merge-base", master, feature) {
+		t.Errorf("CompareCommits base %s and merge base %s", compare.BaseCommit.Sha, compare.MergeBaseCommit.Sha)
+	}
+
+	compare, err = g.CompareCommits(head, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compare.Status != "identical" {
+		t.Errorf("CompareCommits of the same commit = %s, want identical", compare.Status)
+	}
+}
+
+func TestLocalGitResolveRefUnknown(t *testing.T) {
+	remote, _ := newGitRemote(t)
+	if _, err := NewLocalGit(remote, "").ResolveRef("missing"); err == nil {
+		t.Error("ResolveRef of a missing branch did not fail")
+	}
+}
+
+func TestCompareStatus(t *testing.T) {
+	for _, c := range []struct {
+		aheadBy, behindBy int
+		want              string
+	}{
+		{0, 0, "identical"},
+		{2, 0, "ahead"},
+		{0, 3, "behind"},
+		{2, 3, "diverged"},
+	} {
+		if got := compareStatus(c.aheadBy, c.behindBy); got != c.want {
+			t.Errorf("compareStatus(%d, %d) = %s, want %s", c.aheadBy, c.behindBy, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LogLevels are the values accepted by LOG_LEVEL
+var LogLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// SetupLogging writes the logs of the level and above to w, in the text
+// (key=value) or JSON format. What is still logged through the standard log
+// package goes through it too, at the info level.
+func SetupLogging(w io.Writer, level string, format string) error {
+	l, found := LogLevels[level]
+	if !found {
+		return errors.New("unknown log level " + level)
+	}
+	options := &slog.HandlerOptions{Level: l, ReplaceAttr: replaceLogAttr}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return errors.New("unknown log format " + format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// replaceLogAttr writes the errors without the stack trace pkg/errors adds
+// with %+v, and the durations as "1.5s" in JSON too
+func replaceLogAttr(groups []string, a slog.Attr) slog.Attr {
+	switch v := a.Value.Any().(type) {
+	case error:
+		return slog.String(a.Key, v.Error())
+	case time.Duration:
+		return slog.String(a.Key, v.String())
+	}
+	return a
+}
+
+// Fatal logs an error and exits, the args being key value pairs as for
+// slog.Error
+func Fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
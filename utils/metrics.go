@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics collects the operational metrics of the tool itself, the
+// staleness metrics are computed from the snapshot when scraped
+type Metrics struct {
+	lock               sync.Mutex
+	requests           map[[2]string]int
+	rateLimitRemaining int
+	scanDurations      map[string]time.Duration
+}
+
+// AppMetrics is shared by every client made by MakeAppWithDefaults
+var AppMetrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:           make(map[[2]string]int),
+		rateLimitRemaining: -1,
+		scanDurations:      make(map[string]time.Duration),
+	}
+}
+
+// apiResources are the path segments kept to name an endpoint, the others
+// (owners, names, numbers, refs) would make one series per object
+var apiResources = map[string]bool{
+	"repos": true, "projects": true, "graphql": true, "pulls": true, "pull-requests": true,
+	"merge_requests": true, "branches": true, "compare": true, "commits": true, "git": true,
+	"refs": true, "issues": true, "labels": true, "comments": true, "notes": true, "repository": true,
+}
+
+func endpointName(path string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if apiResources[segment] {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return "other"
+	}
+	return strings.Join(segments, "/")
+}
+
+func (m *Metrics) ObserveRequest(req *http.Request, resp *http.Response, err error) {
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[[2]string{endpointName(req.URL.Path), status}]++
+	if err != nil {
+		return
+	}
+	for _, header := range []string{"X-RateLimit-Remaining", "RateLimit-Remaining"} {
+		if remaining, errAtoi := strconv.Atoi(resp.Header.Get(header)); errAtoi == nil {
+			m.rateLimitRemaining = remaining
+		}
+	}
+}
+
+func (m *Metrics) ObserveScan(repo string, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.scanDurations[repo] = duration
+}
+
+// instrumentedTransport counts every request going through the client, and
+// logs it with its duration at the debug level. Only the scheme and host of
+// the URL are logged, the path of a webhook being its secret.
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	metrics *Metrics
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.metrics.ObserveRequest(req, resp, err)
+	logRequest(req, resp, err, time.Since(start))
+	return resp, err
+}
+
+func logRequest(req *http.Request, resp *http.Response, err error, duration time.Duration) {
+	ctx := req.Context()
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	args := []interface{}{"method", req.Method, "host", req.URL.Scheme + "://" + req.URL.Host, "duration", duration}
+	if err != nil {
+		slog.DebugContext(ctx, "Request failed", append(args, "error", err)...)
+		return
+	}
+	args = append(args, "status", resp.StatusCode)
+	for _, header := range []string{"X-RateLimit-Remaining", "RateLimit-Remaining"} {
+		if remaining := resp.Header.Get(header); remaining != "" {
+			args = append(args, "rate_limit_remaining", remaining)
+		}
+	}
+	slog.DebugContext(ctx, "Request", args...)
+}
+
+func NewInstrumentedClient(metrics *Metrics) *http.Client {
+	return &http.Client{Transport: instrumentedTransport{next: http.DefaultTransport, metrics: metrics}}
+}
+
+// MetricsExporter serves the Prometheus text exposition format
+type MetricsExporter struct {
+	Snapshot *Snapshot
+	Metrics  *Metrics
+}
+
+func (e *MetricsExporter) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/metrics", e.serveMetrics)
+}
+
+func labels(pairs ...string) string {
+	parts := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		parts = append(parts, pairs[i]+`="`+value+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func median(xs []int) float64 {
+	sorted := append([]int{}, xs...)
+	sort.Ints(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return float64(sorted[n/2])
+	}
+	return float64(sorted[n/2-1]+sorted[n/2]) / 2
+}
+
+type baseBranchStats struct {
+	repo     string
+	base     string
+	behind   []int
+	buckets  []int
+	oldestAt time.Time
+}
+
+func collectBaseBranchStats(reports []*Report) []*baseBranchStats {
+	stats := []*baseBranchStats{}
+	index := make(map[string]*baseBranchStats)
+	for _, report := range reports {
+		for _, row := range report.Rows {
+			key := report.Repo + " " + row.BaseRef
+			s, found := index[key]
+			if !found {
+				s = &baseBranchStats{repo: report.Repo, base: row.BaseRef, buckets: make([]int, len(behindBucketLabels))}
+				index[key] = s
+				stats = append(stats, s)
+			}
+			s.behind = append(s.behind, row.BehindBy)
+			s.buckets[behindBucket(row.BehindBy)]++
+			if s.oldestAt.IsZero() || row.CreatedAt.Before(s.oldestAt) {
+				s.oldestAt = row.CreatedAt
+			}
+		}
+	}
+	return stats
+}
+
+func (e *MetricsExporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	now := time.Now()
+	stats := collectBaseBranchStats(e.Snapshot.Reports())
+
+	writeMetricHeader(w, "outdated_branches_open_pull_requests", "gauge", "Open pull requests per repository and base branch.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "outdated_branches_open_pull_requests%s %d\n", labels("repo", s.repo, "base", s.base), len(s.behind))
+	}
+	writeMetricHeader(w, "outdated_branches_pull_requests_behind", "gauge", "Open pull requests per bucket of commits behind their base branch.")
+	for _, s := range stats {
+		for i, count := range s.buckets {
+			fmt.Fprintf(w, "outdated_branches_pull_requests_behind%s %d\n", labels("repo", s.repo, "base", s.base, "bucket", behindBucketLabels[i]), count)
+		}
+	}
+	writeMetricHeader(w, "outdated_branches_behind_by_max", "gauge", "Maximum number of commits a pull request is behind its base branch.")
+	for _, s := range stats {
+		max := 0
+		for _, behind := range s.behind {
+			if behind > max {
+				max = behind
+			}
+		}
+		fmt.Fprintf(w, "outdated_branches_behind_by_max%s %d\n", labels("repo", s.repo, "base", s.base), max)
+	}
+	writeMetricHeader(w, "outdated_branches_behind_by_median", "gauge", "Median number of commits the pull requests are behind their base branch.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "outdated_branches_behind_by_median%s %g\n", labels("repo", s.repo, "base", s.base), median(s.behind))
+	}
+	writeMetricHeader(w, "outdated_branches_oldest_pull_request_age_seconds", "gauge", "Age of the oldest open pull request.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "outdated_branches_oldest_pull_request_age_seconds%s %d\n", labels("repo", s.repo, "base", s.base), int64(now.Sub(s.oldestAt).Seconds()))
+	}
+
+	e.Metrics.lock.Lock()
+	defer e.Metrics.lock.Unlock()
+	writeMetricHeader(w, "outdated_branches_api_requests_total", "counter", "Forge API requests by endpoint and status.")
+	keys := [][2]string{}
+	for key := range e.Metrics.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1] })
+	for _, key := range keys {
+		fmt.Fprintf(w, "outdated_branches_api_requests_total%s %d\n", labels("endpoint", key[0], "status", key[1]), e.Metrics.requests[key])
+	}
+	if e.Metrics.rateLimitRemaining >= 0 {
+		writeMetricHeader(w, "outdated_branches_rate_limit_remaining", "gauge", "Requests left in the current rate limit window.")
+		fmt.Fprintf(w, "outdated_branches_rate_limit_remaining %d\n", e.Metrics.rateLimitRemaining)
+	}
+	writeMetricHeader(w, "outdated_branches_scan_duration_seconds", "gauge", "Duration of the last scan of the repository.")
+	repos := []string{}
+	for repo := range e.Metrics.scanDurations {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		fmt.Fprintf(w, "outdated_branches_scan_duration_seconds%s %g\n", labels("repo", repo), e.Metrics.scanDurations[repo].Seconds())
+	}
+}
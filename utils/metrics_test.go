@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	createdAt := time.Now().Add(-time.Hour)
+	snapshot := NewSnapshot(&Config{RepoAuthor: "octo", RepoName: "repo"})
+	snapshot.reports = []*Report{{Repo: "octo/repo", Rows: []ReportRow{
+		{Number: 1, BaseRef: "master", CreatedAt: createdAt.Add(time.Minute)},
+		{Number: 2, BaseRef: "master", BehindBy: 4, CreatedAt: createdAt},
+		{Number: 3, BaseRef: "master", BehindBy: 60, CreatedAt: createdAt.Add(time.Minute)},
+		{Number: 4, BaseRef: "develop", BehindBy: 12, CreatedAt: createdAt.Add(time.Minute)},
+	}}}
+
+	metrics := NewMetrics()
+	headers := http.Header{}
+	headers.Set("X-RateLimit-Remaining", "4321")
+	for _, path := range []string{"/repos/octo/repo/pulls/1", "/repos/octo/repo/pulls/2", "/repos/octo/repo/compare/a...b"} {
+		req := httptest.NewRequest("GET", "https://api.github.com"+path, nil)
+		metrics.ObserveRequest(req, &http.Response{StatusCode: http.StatusOK, Header: headers}, nil)
+	}
+	req := httptest.NewRequest("GET", "https://api.github.com/repos/octo/repo/branches/master", nil)
+	metrics.ObserveRequest(req, nil, io.ErrUnexpectedEOF)
+	metrics.ObserveScan("octo/repo", 1500*time.Millisecond)
+
+	mux := http.NewServeMux()
+	(&MetricsExporter{Snapshot: snapshot, Metrics: metrics}).Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type %q", contentType)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exposition := string(body)
+
+	for _, want := range []string{
+		"# HELP outdated_branches_open_pull_requests Open pull requests per repository and base branch.\n# TYPE outdated_branches_open_pull_requests gauge\n",
+		`outdated_branches_open_pull_requests{repo="octo/repo",base="master"} 3` + "\n",
+		`outdated_branches_open_pull_requests{repo="octo/repo",base="develop"} 1` + "\n",
+		`outdated_branches_pull_requests_behind{repo="octo/repo",base="master",bucket="0"} 1` + "\n",
+		`outdated_branches_pull_requests_behind{repo="octo/repo",base="master",bucket="1-10"} 1` + "\n",
+		`outdated_branches_pull_requests_behind{repo="octo/repo",base="master",bucket="11-50"} 0` + "\n",
+		`outdated_branches_pull_requests_behind{repo="octo/repo",base="master",bucket="50+"} 1` + "\n",
+		`outdated_branches_pull_requests_behind{repo="octo/repo",base="develop",bucket="11-50"} 1` + "\n",
+		`outdated_branches_behind_by_max{repo="octo/repo",base="master"} 60` + "\n",
+		`outdated_branches_behind_by_median{repo="octo/repo",base="master"} 4` + "\n",
+		`outdated_branches_behind_by_median{repo="octo/repo",base="develop"} 12` + "\n",
+		"# TYPE outdated_branches_api_requests_total counter\n",
+		`outdated_branches_api_requests_total{endpoint="repos/branches",status="error"} 1` + "\n",
+		`outdated_branches_api_requests_total{endpoint="repos/compare",status="200"} 1` + "\n",
+		`outdated_branches_api_requests_total{endpoint="repos/pulls",status="200"} 2` + "\n",
+		"outdated_branches_rate_limit_remaining 4321\n",
+		`outdated_branches_scan_duration_seconds{repo="octo/repo"} 1.5` + "\n",
+	} {
+		if !strings.Contains(exposition, want) {
+			t.Errorf("the exposition does not contain %q:\n%s", want, exposition)
+		}
+	}
+
+	// the oldest pull request of master was opened an hour ago
+	prefix := `outdated_branches_oldest_pull_request_age_seconds{repo="octo/repo",base="master"} `
+	age := -1
+	for _, line := range strings.Split(exposition, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			age, _ = strconv.Atoi(strings.TrimPrefix(line, prefix))
+		}
+	}
+	if age < 3600 || age > 3660 {
+		t.Errorf("oldest pull request of master %d seconds old, want an hour", age)
+	}
+
+	// every sample belongs to a metric declared before it
+	declared := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(exposition), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			declared[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		if !declared[name] {
+			t.Errorf("sample %q before the TYPE of %s", line, name)
+		}
+	}
+}
+
+func TestMetricsLabelsEscaping(t *testing.T) {
+	if got, want := labels("repo", `octo/"repo"`, "base", "a\\b\nc"), `{repo="octo/\"repo\"",base="a\\b\nc"}`; got != want {
+		t.Errorf("labels = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"sort"
+	"time"
+)
+
+// MilestoneGroup summarizes the release readiness of the pull requests of a
+// milestone. Conflicting needs PREDICT_CONFLICTS and Approved needs
+// REVIEW_STATUS, they stay at 0 otherwise.
+type MilestoneGroup struct {
+	Milestone   string    `json:"milestone"`
+	DueOn       time.Time `json:"due_on"`
+	Open        int       `json:"open"`
+	Behind      int       `json:"behind"`
+	Conflicting int       `json:"conflicting"`
+	Approved    int       `json:"approved"`
+	// DueSoon flags the milestones due within the window which still have
+	// pull requests behind their base
+	DueSoon bool        `json:"due_soon"`
+	Rows    []ReportRow `json:"rows"`
+}
+
+// NoMilestone is the title of the group of the pull requests without one
+const NoMilestone = "No milestone"
+
+// GroupByMilestone sorts the groups by due date, the milestones without one
+// and then the pull requests without milestone coming last
+func GroupByMilestone(report *Report, dueWithin time.Duration) []MilestoneGroup {
+	groups := []MilestoneGroup{}
+	index := make(map[string]int)
+	for _, row := range report.Rows {
+		title := row.Milestone
+		if title == "" {
+			title = NoMilestone
+		}
+		i, found := index[title]
+		if !found {
+			i = len(groups)
+			index[title] = i
+			groups = append(groups, MilestoneGroup{Milestone: title, DueOn: row.MilestoneDueOn})
+		}
+		group := &groups[i]
+		group.Open++
+		if row.BehindBy > 0 {
+			group.Behind++
+		}
+		if len(row.LikelyConflicts) > 0 {
+			group.Conflicting++
+		}
+		if row.Approvals > 0 && !row.ChangesRequested {
+			group.Approved++
+		}
+		group.Rows = append(group.Rows, row)
+	}
+
+	deadline := time.Now().Add(dueWithin)
+	for i := range groups {
+		due := groups[i].DueOn
+		groups[i].DueSoon = !due.IsZero() && due.Before(deadline) && groups[i].Behind > 0
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		x, y := groups[i], groups[j]
+		if (x.Milestone == NoMilestone) != (y.Milestone == NoMilestone) {
+			return y.Milestone == NoMilestone
+		}
+		if x.DueOn.IsZero() != y.DueOn.IsZero() {
+			return y.DueOn.IsZero()
+		}
+		if !x.DueOn.Equal(y.DueOn) {
+			return x.DueOn.Before(y.DueOn)
+		}
+		return x.Milestone < y.Milestone
+	})
+	return groups
+}
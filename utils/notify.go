@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Digest summarizes a report for the chat notifications
+type Digest struct {
+	Repo        string
+	GeneratedAt time.Time
+	OpenCount   int
+	BehindCount int
+	Top         []ReportRow
+	// Handles maps the Github logins, and the CODEOWNERS teams written
+	// "org/team", to the chat handles to mention
+	Handles map[string]string
+}
+
+// NewDigest keeps the topN pull requests the most behind their base branch
+func NewDigest(report *Report, topN int, handles map[string]string) Digest {
+	digest := Digest{Repo: report.Repo, GeneratedAt: report.GeneratedAt, OpenCount: len(report.Rows), Handles: handles}
+	rows := []ReportRow{}
+	for _, row := range report.Rows {
+		if row.BehindBy > 0 {
+			digest.BehindCount++
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].BehindBy > rows[j].BehindBy })
+	if len(rows) > topN {
+		rows = rows[:topN]
+	}
+	digest.Top = rows
+	return digest
+}
+
+func (d Digest) Summary() string {
+	return fmt.Sprintf("%s: %d open pull requests, %d behind their base branch", d.Repo, d.OpenCount, d.BehindCount)
+}
+
+// handle looks the owners up without their leading "@", e.g. "org/team"
+func (d Digest) handle(login string) (string, bool) {
+	handle, found := d.Handles[strings.TrimPrefix(login, "@")]
+	return handle, found && handle != ""
+}
+
+// LoadLoginMap reads a JSON object mapping Github logins to chat handles or
+// email addresses
+func LoadLoginMap(path string) (map[string]string, error) {
+	handles := make(map[string]string)
+	if path == "" {
+		return handles, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return handles, json.NewDecoder(f).Decode(&handles)
+}
+
+type Notifier interface {
+	Notify(digest Digest) error
+}
+
+// postJSON never puts the webhook URL in its errors: its path is the secret
+// token of the webhook
+func postJSON(client *http.Client, webhookURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackNotifier posts a Block Kit message to an incoming webhook, the handles
+// being Slack member IDs
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// slackEscape escapes the control characters of the mrkdwn format. A "|"
+// ends the URL of a link and has no escape, it is replaced by the fullwidth
+// vertical line.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "|", "｜").Replace(text)
+}
+
+func (s SlackNotifier) Payload(digest Digest) map[string]interface{} {
+	text := func(kind string, value string) map[string]interface{} {
+		return map[string]interface{}{"type": kind, "text": value}
+	}
+	blocks := []map[string]interface{}{
+		{"type": "header", "text": text("plain_text", "Outdated pull requests")},
+		{"type": "section", "text": text("mrkdwn", digest.Summary())},
+	}
+	mention := func(login string) string {
+		if handle, found := digest.handle(login); found {
+			return "<@" + handle + ">"
+		}
+		return slackEscape(login)
+	}
+	for _, row := range digest.Top {
+		line := fmt.Sprintf("<%s|#%d %s> by %s: %d behind %s", row.HTMLURL, row.Number, slackEscape(row.Title), mention(row.Author), row.BehindBy, row.BaseRef)
+		if len(row.Owners) > 0 {
+			owners := []string{}
+			for _, owner := range row.Owners {
+				owners = append(owners, mention(owner))
+			}
+			line += ", owned by " + strings.Join(owners, " ")
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "text": text("mrkdwn", line)})
+	}
+	return map[string]interface{}{"text": digest.Summary(), "blocks": blocks}
+}
+
+func (s SlackNotifier) Notify(digest Digest) error {
+	return postJSON(s.Client, s.WebhookURL, s.Payload(digest))
+}
+
+// TeamsNotifier posts an Adaptive Card to an incoming webhook, the handles
+// being the Teams user principal names (usually the email addresses)
+type TeamsNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func (t TeamsNotifier) Payload(digest Digest) map[string]interface{} {
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "size": "Large", "weight": "Bolder", "text": "Outdated pull requests"},
+		{"type": "TextBlock", "wrap": true, "text": digest.Summary()},
+	}
+	// a single entity per login, however many times it is mentioned, as an
+	// author or as an owner written with a leading "@"
+	entities := []map[string]interface{}{}
+	mentioned := make(map[string]bool)
+	mention := func(login string) string {
+		handle, found := digest.handle(login)
+		if !found {
+			return login
+		}
+		name := strings.TrimPrefix(login, "@")
+		text := "<at>" + name + "</at>"
+		if mentioned[name] {
+			return text
+		}
+		mentioned[name] = true
+		entities = append(entities, map[string]interface{}{
+			"type":      "mention",
+			"text":      text,
+			"mentioned": map[string]string{"id": handle, "name": name},
+		})
+		return text
+	}
+	for _, row := range digest.Top {
+		line := fmt.Sprintf("[#%d %s](%s) by %s: %d behind %s", row.Number, row.Title, row.HTMLURL, mention(row.Author), row.BehindBy, row.BaseRef)
+		if len(row.Owners) > 0 {
+			owners := []string{}
+			for _, owner := range row.Owners {
+				owners = append(owners, mention(owner))
+			}
+			line += ", owned by " + strings.Join(owners, " ")
+		}
+		body = append(body, map[string]interface{}{"type": "TextBlock", "wrap": true, "text": line})
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+		"msteams": map[string]interface{}{"entities": entities},
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	}
+}
+
+func (t TeamsNotifier) Notify(digest Digest) error {
+	return postJSON(t.Client, t.WebhookURL, t.Payload(digest))
+}
+
+// NewNotifiers returns a notifier per configured webhook URL
+func NewNotifiers(config *Config, client *http.Client) []Notifier {
+	notifiers := []Notifier{}
+	if config.SlackWebhookURL != "" {
+		notifiers = append(notifiers, SlackNotifier{WebhookURL: config.SlackWebhookURL, Client: client})
+	}
+	if config.TeamsWebhookURL != "" {
+		notifiers = append(notifiers, TeamsNotifier{WebhookURL: config.TeamsWebhookURL, Client: client})
+	}
+	if config.SMTPHost != "" && len(config.SMTPTo) > 0 {
+		notifiers = append(notifiers, NewEmailNotifier(config))
+	}
+	return notifiers
+}
+
+// notifierName is used in the logs, e.g. "SlackNotifier"
+func notifierName(n Notifier) string {
+	name := fmt.Sprintf("%T", n)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// NotifyAll sends the digest to every notifier and reports the failures
+// together
+func NotifyAll(notifiers []Notifier, digest Digest) error {
+	failures := []string{}
+	for _, n := range notifiers {
+		if err := n.Notify(digest); err != nil {
+			failures = append(failures, notifierName(n)+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d notification(s) failed: %s", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebhooks records the payloads posted to /slack and /teams, and fails
+// the ones posted under /down
+type fakeWebhooks struct {
+	lock     sync.Mutex
+	payloads map[string]map[string]interface{}
+}
+
+func (f *fakeWebhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/down") {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	payload := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.payloads[r.URL.Path] = payload
+}
+
+func notifyReport() *Report {
+	return &Report{
+		Repo:        "octo/repo",
+		GeneratedAt: time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC),
+		Rows: []ReportRow{
+			{Number: 1, Title: "Fix a | b & <c>", Author: "alice", HTMLURL: "https://github.com/octo/repo/pull/1", BaseRef: "master", BehindBy: 3, Owners: []string{"@alice", "@octo/core"}},
+			{Number: 2, Title: "Up to date", Author: "bob", HTMLURL: "https://github.com/octo/repo/pull/2", BaseRef: "master"},
+			{Number: 3, Title: "Refactor", Author: "alice", HTMLURL: "https://github.com/octo/repo/pull/3", BaseRef: "master", BehindBy: 8},
+		},
+	}
+}
+
+func TestNewDigest(t *testing.T) {
+	digest := NewDigest(notifyReport(), 1, nil)
+	if digest.OpenCount != 3 || digest.BehindCount != 2 {
+		t.Errorf("%d open, %d behind, want 3 open, 2 behind", digest.OpenCount, digest.BehindCount)
+	}
+	if len(digest.Top) != 1 || digest.Top[0].Number != 3 {
+		t.Errorf("top %+v, want #3 alone", digest.Top)
+	}
+}
+
+func TestNotifyAllSlackTeams(t *testing.T) {
+	fake := &fakeWebhooks{payloads: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := &Config{SlackWebhookURL: server.URL + "/slack", TeamsWebhookURL: server.URL + "/teams"}
+	handles := map[string]string{"alice": "U123", "octo/core": "T456"}
+	digest := NewDigest(notifyReport(), 10, handles)
+	if err := NotifyAll(NewNotifiers(config, server.Client()), digest); err != nil {
+		t.Fatal(err)
+	}
+
+	slack, err := json.Marshal(fake.payloads["/slack"])
+	if err != nil || fake.payloads["/slack"] == nil {
+		t.Fatal("nothing posted to Slack")
+	}
+	for _, want := range []string{
+		"#1 Fix a ｜ b \\u0026amp; \\u0026lt;c\\u0026gt;",
+		"by \\u003c@U123\\u003e",
+		"owned by \\u003c@U123\\u003e \\u003c@T456\\u003e",
+	} {
+		if !strings.Contains(string(slack), want) {
+			t.Errorf("Slack payload %s does not contain %s", slack, want)
+		}
+	}
+
+	teams := fake.payloads["/teams"]
+	if teams == nil {
+		t.Fatal("nothing posted to Teams")
+	}
+	card := teams["attachments"].([]interface{})[0].(map[string]interface{})["content"].(map[string]interface{})
+	entities := card["msteams"].(map[string]interface{})["entities"].([]interface{})
+	mentioned := []string{}
+	for _, entity := range entities {
+		mentioned = append(mentioned, entity.(map[string]interface{})["mentioned"].(map[string]interface{})["id"].(string))
+	}
+	// alice is mentioned three times, as the author of both pull requests and
+	// as an owner, but has a single entity
+	if strings.Join(mentioned, ",") != "U123,T456" {
+		t.Errorf("Teams entities for %v, want U123,T456", mentioned)
+	}
+}
+
+func TestNotifyAllFailures(t *testing.T) {
+	fake := &fakeWebhooks{payloads: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := &Config{SlackWebhookURL: server.URL + "/slack", TeamsWebhookURL: server.URL + "/down"}
+	err := NotifyAll(NewNotifiers(config, server.Client()), NewDigest(notifyReport(), 10, nil))
+	if err == nil || !strings.Contains(err.Error(), "TeamsNotifier") || strings.Contains(err.Error(), "SlackNotifier") {
+		t.Errorf("error %v, want the Teams failure only", err)
+	}
+	if fake.payloads["/slack"] == nil {
+		t.Error("the Slack digest was not sent despite the Teams failure")
+	}
+}
+
+func TestNotifyErrorsHideTheWebhookURL(t *testing.T) {
+	fake := &fakeWebhooks{payloads: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(fake)
+	closed := httptest.NewServer(fake)
+	closed.Close()
+	defer server.Close()
+
+	logs := bytes.Buffer{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	config := &Config{SlackWebhookURL: closed.URL + "/T000/B000/secret", TeamsWebhookURL: server.URL + "/down/secret"}
+	err := NotifyAll(NewNotifiers(config, NewInstrumentedClient(NewMetrics())), NewDigest(notifyReport(), 10, nil))
+	if err == nil || !strings.Contains(err.Error(), "SlackNotifier") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("error %v, want both failures", err)
+	}
+	for _, out := range []string{err.Error(), logs.String()} {
+		if strings.Contains(out, "secret") {
+			t.Errorf("the webhook URL leaked: %s", out)
+		}
+	}
+	if !strings.Contains(logs.String(), "host="+server.URL) {
+		t.Errorf("the requests were not logged with their host:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OutputFormats are the values accepted by OUTPUT_FORMAT
+var OutputFormats = []string{"table", "json"}
+
+func writeJSONIndent(w io.Writer, payload interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(payload)
+}
+
+func unknownFormat(format string) error {
+	return errors.New("unknown output format " + format)
+}
+
+type tableColumn struct {
+	header string
+	value  func(ReportRow) string
+}
+
+// reportColumns adds the optional columns of the data the report holds
+func reportColumns(report *Report) []tableColumn {
+	columns := []tableColumn{
+		{"PR ID", func(row ReportRow) string { return "#" + strconv.Itoa(row.Number) }},
+		{"Branch", func(row ReportRow) string { return row.HeadRef }},
+		{"Base Branch", func(row ReportRow) string { return row.BaseRef }},
+		{"CommitDiff", func(row ReportRow) string { return strconv.Itoa(row.TotalCommits) }},
+		{"Created At", func(row ReportRow) string { return row.CreatedAt.Format(time.UnixDate) }},
+	}
+	if report.ConflictsPredicted {
+		columns = append(columns,
+			tableColumn{"Overlap", func(row ReportRow) string { return strconv.Itoa(len(row.LikelyConflicts)) }},
+			tableColumn{"Likely Conflicts", func(row ReportRow) string { return strings.Join(row.LikelyConflicts, ", ") }},
+		)
+	}
+	if report.ReviewsFetched {
+		columns = append(columns, tableColumn{"Reviews", ReportRow.ReviewSummary})
+	}
+	if report.CIFetched {
+		columns = append(columns, tableColumn{"CI", func(row ReportRow) string { return row.CIStatus }})
+	}
+	for _, row := range report.Rows {
+		if row.StackRoot != 0 {
+			columns = append(columns, tableColumn{"Stack", ReportRow.StackSummary})
+			break
+		}
+	}
+	if report.OwnersFetched {
+		columns = append(columns, tableColumn{"Owners", func(row ReportRow) string { return strings.Join(row.Owners, " ") }})
+	}
+	return columns
+}
+
+func writeTable(w io.Writer, columns []tableColumn, rows []ReportRow) {
+	headers := []string{}
+	rulers := []string{}
+	for _, column := range columns {
+		headers = append(headers, column.header)
+		rulers = append(rulers, strings.Repeat("-", len(column.header)))
+	}
+	fmt.Fprintln(w, strings.Join(headers, " | "))
+	fmt.Fprintln(w, strings.Join(rulers, "-|-"))
+	for _, row := range rows {
+		values := []string{}
+		for _, column := range columns {
+			values = append(values, column.value(row))
+		}
+		fmt.Fprintln(w, strings.Join(values, " | "))
+	}
+}
+
+func WriteReport(w io.Writer, format string, report *Report) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, report)
+	case "table":
+		writeTable(w, reportColumns(report), report.Rows)
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+func WriteDiff(w io.Writer, format string, diff *RunDiff) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, diff)
+	case "table":
+		if diff.Since.IsZero() {
+			fmt.Fprintln(w, "No previous run of "+diff.Repo+", every PR is new")
+		} else {
+			fmt.Fprintln(w, "Changes on "+diff.Repo+" since "+diff.Since.Format(time.UnixDate))
+		}
+		sections := []struct {
+			title   string
+			entries []DiffEntry
+		}{
+			{"Opened", diff.Opened},
+			{"Outdated (" + strconv.Itoa(diff.Threshold) + "+ commits behind)", diff.Outdated},
+			{"Rebased", diff.Rebased},
+			{"Closed or merged", diff.Closed},
+		}
+		for _, section := range sections {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, section.title+": "+strconv.Itoa(len(section.entries)))
+			if len(section.entries) == 0 {
+				continue
+			}
+			fmt.Fprintln(w, "PR ID | Author | Base Branch | Previous Behind | Behind")
+			fmt.Fprintln(w, "------|--------|-------------|-----------------|-------")
+			for _, e := range section.entries {
+				fmt.Fprintln(w, "#"+strconv.Itoa(e.Number)+" | "+e.Author+" | "+e.BaseRef+" | "+strconv.Itoa(e.PreviousBehindBy)+" | "+strconv.Itoa(e.BehindBy))
+			}
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+// WriteMilestones prints a summary line per milestone followed by its pull
+// requests, the milestones due soon being flagged
+func WriteMilestones(w io.Writer, format string, report *Report, groups []MilestoneGroup) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, groups)
+	case "table":
+		columns := reportColumns(report)
+		for i, group := range groups {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			due := "no due date"
+			if !group.DueOn.IsZero() {
+				due = "due " + group.DueOn.Format("2006-01-02")
+			}
+			line := fmt.Sprintf("%s (%s): %d open, %d behind, %d conflicting, %d approved", group.Milestone, due, group.Open, group.Behind, group.Conflicting, group.Approved)
+			if group.DueSoon {
+				line = "[DUE SOON] " + line
+			}
+			fmt.Fprintln(w, line)
+			writeTable(w, columns, group.Rows)
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+func WriteRelease(w io.Writer, format string, report *ReleaseReport) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, report)
+	case "table":
+		if len(report.Branches) == 0 {
+			fmt.Fprintln(w, "No release branch found in "+report.Repo)
+			return nil
+		}
+		details := report.details
+		if details == nil {
+			details = &Report{}
+		}
+		columns := append(reportColumns(details), tableColumn{"Rebased", func(row ReportRow) string {
+			return strconv.FormatBool(row.BehindBy == 0)
+		}})
+		for i, release := range report.Branches {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s (%s): %d ahead of %s, %d behind, %d open PRs, %d not rebased on the tip\n",
+				release.Branch, release.Sha, release.AheadBy, report.DefaultBranch, release.BehindBy, len(release.Rows), release.NotRebased())
+			if len(release.Rows) > 0 {
+				writeTable(w, columns, release.Rows)
+			}
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+// WriteBackports lists the backports still missing, the JSON output keeping
+// the backported ones too
+func WriteBackports(w io.Writer, format string, report *BackportReport) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, report)
+	case "table":
+		missing := report.Missing()
+		fmt.Fprintf(w, "%d backport(s) requested on %s since %s, %d missing\n", len(report.Entries), report.Repo, report.Since.Format("2006-01-02"), len(missing))
+		if len(missing) == 0 {
+			return nil
+		}
+		fmt.Fprintln(w, "PR ID | Author | Merged At | Merge Commit | Target Branch | Status")
+		fmt.Fprintln(w, "------|--------|-----------|--------------|---------------|-------")
+		for _, e := range missing {
+			fmt.Fprintln(w, "#"+strconv.Itoa(e.Number)+" | "+e.Author+" | "+e.MergedAt.Format(time.UnixDate)+" | "+e.MergeCommitSha+" | "+e.TargetBranch+" | "+e.Status)
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+func writeStackNode(w io.Writer, node *StackNode, prefix string, last bool) {
+	branch, indent := "|-- ", "|   "
+	if last {
+		branch, indent = "`-- ", "    "
+	}
+	row := node.Row
+	fmt.Fprintf(w, "%s%s#%d %s (%d behind %s, %d behind %s through the stack)\n", prefix, branch, row.Number, row.HeadRef, row.BehindBy, row.BaseRef, row.StackBehindBy, row.Trunk)
+	for i, child := range node.Children {
+		writeStackNode(w, child, prefix+indent, i == len(node.Children)-1)
+	}
+}
+
+// WriteStacks draws every stack as a tree under its trunk branch
+func WriteStacks(w io.Writer, format string, report *Report) error {
+	stacks := Stacks(report.Rows)
+	switch format {
+	case "json":
+		return writeJSONIndent(w, stacks)
+	case "table":
+		if len(stacks) == 0 {
+			fmt.Fprintln(w, "No stacked PRs in "+report.Repo)
+			return nil
+		}
+		for i, root := range stacks {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, root.Row.BaseRef)
+			writeStackNode(w, root, "", true)
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+func WriteTrends(w io.Writer, format string, trends []TrendRow) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, trends)
+	case "table":
+		fmt.Fprintln(w, "Week | Runs | Open PRs | Avg Behind | Max Behind")
+		fmt.Fprintln(w, "-----|------|----------|------------|-----------")
+		for _, t := range trends {
+			fmt.Fprintf(w, "%s | %d | %.1f | %.1f | %d\n", t.Week, t.Runs, t.OpenPRs, t.AvgBehind, t.MaxBehind)
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+func WriteBranchReport(w io.Writer, format string, report *BranchReport) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, report)
+	case "table":
+		fmt.Fprintln(w, "Branch | Sha | Protected | Open PR | Age Days | Status | Ahead | Behind "+report.DefaultBranch)
+		fmt.Fprintln(w, "-------|-----|-----------|---------|----------|--------|-------|-------"+strings.Repeat("-", len(report.DefaultBranch)+1))
+		for _, row := range report.Rows {
+			fmt.Fprintln(w, row.Branch+" | "+row.Sha+" | "+strconv.FormatBool(row.Protected)+" | "+strconv.FormatBool(row.HasOpenPR)+" | "+
+				strconv.Itoa(row.AgeDays)+" | "+row.Status+" | "+strconv.Itoa(row.AheadBy)+" | "+strconv.Itoa(row.BehindBy))
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+// WriteDoctor prints a line per check, prefixed by OK or FAIL. Any format
+// other than json falls back to the table, an invalid OUTPUT_FORMAT being one
+// of the failed checks to print.
+func WriteDoctor(w io.Writer, format string, checks []DoctorCheck) error {
+	if format == "json" {
+		return writeJSONIndent(w, checks)
+	}
+	for _, check := range checks {
+		if check.OK() {
+			fmt.Fprintf(w, "OK   %s: %s\n", check.Name, check.Detail)
+		} else {
+			fmt.Fprintf(w, "FAIL %s: %s\n", check.Name, check.Error)
+		}
+	}
+	return nil
+}
+
+func WriteCleanupCandidates(w io.Writer, format string, candidates []BranchCleanupEntry) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, candidates)
+	case "table":
+		fmt.Fprintln(w, "Branch | Sha | Reason | Last Commit At")
+		fmt.Fprintln(w, "-------|-----|--------|---------------")
+		for _, c := range candidates {
+			fmt.Fprintln(w, c.Branch+" | "+c.Sha+" | "+c.Reason+" | "+c.LastCommitAt.Format(time.UnixDate))
+		}
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
+
+// WriteActionPlan lists the pull requests the actions are about to change
+func WriteActionPlan(w io.Writer, format string, rows []ReportRow, actions []string) error {
+	switch format {
+	case "json":
+		return writeJSONIndent(w, struct {
+			Actions []string    `json:"actions"`
+			Rows    []ReportRow `json:"rows"`
+		}{actions, rows})
+	case "table":
+		writeTable(w, []tableColumn{
+			{"PR ID", func(row ReportRow) string { return "#" + strconv.Itoa(row.Number) }},
+			{"Author", func(row ReportRow) string { return row.Author }},
+			{"Behind", func(row ReportRow) string { return strconv.Itoa(row.BehindBy) }},
+			{"Actions", func(row ReportRow) string { return strings.Join(actions, ", ") }},
+		}, rows)
+		return nil
+	default:
+		return unknownFormat(format)
+	}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import "github.com/pkg/errors"
+
+// Provider abstracts the forge hosting the repository. Change requests and
+// comparisons are mapped onto the Github models, so that the report does not
+// depend on where the data came from.
+type Provider interface {
+	CommitBackend
+	ListChangeRequests() (PullRequestList, error)
+	BranchHead(branch string) (string, error)
+	AddLabels(number int, labels []string) error
+	AddComment(number int, comment string) error
+}
+
+func (a *AppMutex) ListChangeRequests() (PullRequestList, error) {
+	return a.RetrievePullRequestsWithPagination(0)
+}
+
+func (a *AppMutex) BranchHead(branch string) (string, error) {
+	return a.GetLastCommit(branch)
+}
+
+// NewProvider returns the provider selected by Config.Provider, Github being
+// served by the app itself. Gitea and Bitbucket have no public instance to
+// fall back to, their ProviderURL is required.
+func NewProvider(app *AppMutex) (Provider, error) {
+	missingURL := errors.New("PROVIDER_URL is not set for the " + app.Config.Provider + " provider")
+	switch app.Config.Provider {
+	case "", "github":
+		return app, nil
+	case "gitlab":
+		return NewGitlabProvider(app.Config, app.Client), nil
+	case "gitea", "forgejo":
+		if app.Config.ProviderURL == "" {
+			return nil, missingURL
+		}
+		return NewGiteaProvider(app.Config, app.Client), nil
+	case "bitbucket":
+		if app.Config.ProviderURL == "" {
+			return nil, missingURL
+		}
+		return NewBitbucketProvider(app.Config, app.Client), nil
+	default:
+		return nil, errors.New("unknown provider " + app.Config.Provider)
+	}
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewProviderURL(t *testing.T) {
+	for _, test := range []struct {
+		provider    string
+		providerURL string
+		apiURL      string
+		err         string
+	}{
+		{"github", "", "", ""},
+		{"gitlab", "", "https://gitlab.com/api/v4/projects/octo%2Frepo/merge_requests", ""},
+		{"gitlab", "https://gitlab.example.com/", "https://gitlab.example.com/api/v4/projects/octo%2Frepo/merge_requests", ""},
+		{"gitea", "", "", "PROVIDER_URL is not set for the gitea provider"},
+		{"forgejo", "", "", "PROVIDER_URL is not set for the forgejo provider"},
+		{"bitbucket", "", "", "PROVIDER_URL is not set for the bitbucket provider"},
+		{"gitea", "https://gitea.example.com", "https://gitea.example.com/api/v1/repos/octo/repo/pulls", ""},
+		{"bitbucket", "https://bitbucket.example.com", "https://bitbucket.example.com/rest/api/1.0/projects/octo/repos/repo/pull-requests", ""},
+		{"sourcehut", "", "", "unknown provider sourcehut"},
+	} {
+		app := MakeAppWithDefaults()
+		app.Config = &Config{RepoAuthor: "octo", RepoName: "repo", Provider: test.provider, ProviderURL: test.providerURL}
+		provider, err := NewProvider(&app)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("NewProvider(%s, %q) error %v, want %q", test.provider, test.providerURL, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewProvider(%s, %q): %v", test.provider, test.providerURL, err)
+			continue
+		}
+		apiURL := ""
+		switch p := provider.(type) {
+		case *GitlabProvider:
+			apiURL = p.apiURL("/merge_requests")
+		case *GiteaProvider:
+			apiURL = p.apiURL("/pulls")
+		case *BitbucketProvider:
+			apiURL = p.apiURL("/pull-requests")
+		}
+		if apiURL != test.apiURL {
+			t.Errorf("NewProvider(%s, %q) calls %q, want %q", test.provider, test.providerURL, apiURL, test.apiURL)
+		}
+	}
+}
+
+func TestDoctorProviderURL(t *testing.T) {
+	app := MakeAppWithDefaults()
+	app.Config = &Config{RepoAuthor: "octo", RepoName: "repo", Provider: "bitbucket", OutputFormat: "table"}
+	for _, check := range app.Doctor() {
+		if check.Name == "provider" {
+			if !strings.Contains(check.Error, "PROVIDER_URL") {
+				t.Errorf("provider check %+v, want it to fail on PROVIDER_URL", check)
+			}
+			return
+		}
+	}
+	t.Error("no provider check")
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// ReleaseBranch lists the pull requests targeting a release branch, compared
+// with its tip, and how far the branch is from the default branch
+type ReleaseBranch struct {
+	Branch string `json:"branch"`
+	Sha    string `json:"sha"`
+	// AheadBy are the commits of the release branch missing from the default
+	// branch, BehindBy the commits of the default branch not released
+	Status   string      `json:"status"`
+	AheadBy  int         `json:"ahead_by"`
+	BehindBy int         `json:"behind_by"`
+	Rows     []ReportRow `json:"rows"`
+}
+
+type ReleaseReport struct {
+	Repo          string          `json:"repo"`
+	DefaultBranch string          `json:"default_branch"`
+	GeneratedAt   time.Time       `json:"generated_at"`
+	Branches      []ReleaseBranch `json:"branches"`
+	// details holds the optional data fetched for the rows, for the columns
+	// of the table
+	details *Report
+}
+
+// IsReleaseBranch matches the name against the patterns, "*" not matching
+// slashes
+func IsReleaseBranch(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildReleaseReport finds the release branches and compares the pull
+// requests targeting them with the tip of the branch, rather than with the
+// base commit recorded on the pull request, so that a pull request is only
+// up to date once rebased on the latest state of the release
+func (a *AppMutex) BuildReleaseReport(branches BranchList, pullRequests PullRequestList) (*ReleaseReport, error) {
+	defaultBranch, err := a.GetDefaultBranch()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := NewCommitBackend(a, a)
+	if err != nil {
+		return nil, err
+	}
+
+	tips := make(map[string]string)
+	releases := []ReleaseBranch{}
+	for _, b := range branches {
+		if !IsReleaseBranch(a.Config.ReleaseBranches, b.Name) {
+			continue
+		}
+		compare, err := a.CompareCommits(defaultBranch, b.Commit.Sha)
+		if err != nil {
+			return nil, err
+		}
+		tips[b.Name] = b.Commit.Sha
+		releases = append(releases, ReleaseBranch{
+			Branch:   b.Name,
+			Sha:      b.Commit.Sha,
+			Status:   compare.Status,
+			AheadBy:  compare.AheadBy,
+			BehindBy: compare.BehindBy,
+			Rows:     []ReportRow{},
+		})
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Branch < releases[j].Branch })
+
+	targeting := PullRequestList{}
+	for _, pr := range pullRequests {
+		if tip, found := tips[pr.Base.Ref]; found {
+			pr.Base.Sha = tip
+			targeting = append(targeting, pr)
+		}
+	}
+	report, err := BuildReport(a.Config.Repo(), targeting, backend)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.AddDetails(report); err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	for i, release := range releases {
+		index[release.Branch] = i
+	}
+	for _, row := range report.Rows {
+		release := &releases[index[row.BaseRef]]
+		release.Rows = append(release.Rows, row)
+	}
+	return &ReleaseReport{Repo: a.Config.Repo(), DefaultBranch: defaultBranch, GeneratedAt: report.GeneratedAt, Branches: releases, details: report}, nil
+}
+
+// NotRebased counts the pull requests behind the tip of the branch
+func (r ReleaseBranch) NotRebased() int {
+	count := 0
+	for _, row := range r.Rows {
+		if row.BehindBy > 0 {
+			count++
+		}
+	}
+	return count
+}
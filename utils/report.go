@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// ReportRow is the outcome of the comparison of one open pull request with
+// its base branch
+type ReportRow struct {
+	Repo         string    `json:"repo"`
+	Number       int       `json:"number"`
+	Title        string    `json:"title"`
+	Author       string    `json:"author"`
+	HTMLURL      string    `json:"html_url"`
+	HeadRef      string    `json:"head_ref"`
+	HeadSha      string    `json:"head_sha"`
+	BaseRef      string    `json:"base_ref"`
+	BaseSha      string    `json:"base_sha"`
+	Status       string    `json:"status"`
+	AheadBy      int       `json:"ahead_by"`
+	BehindBy     int       `json:"behind_by"`
+	TotalCommits int       `json:"total_commits"`
+	CreatedAt    time.Time `json:"created_at"`
+	Labels       []string  `json:"labels"`
+	// Fork is set for the pull requests whose head branch is in another
+	// repository
+	Fork bool `json:"fork"`
+	// Milestone is empty for the pull requests without one, as is its due
+	// date for the milestones without one
+	Milestone      string    `json:"milestone,omitempty"`
+	MilestoneDueOn time.Time `json:"milestone_due_on"`
+	// LikelyConflicts are the paths changed both by the pull request and on
+	// its base branch, only filled when the conflicts are predicted
+	LikelyConflicts []string `json:"likely_conflicts,omitempty"`
+	// ChangedFiles and MissingCommits are only filled with COMPARE_DETAILS
+	ChangedFiles   []GithubFile   `json:"changed_files,omitempty"`
+	MissingCommits []GithubCommit `json:"missing_commits,omitempty"`
+	// PendingReviewers are the requested users and teams, Approvals and
+	// ChangesRequested are only filled with REVIEW_STATUS
+	PendingReviewers []string `json:"pending_reviewers"`
+	Approvals        int      `json:"approvals"`
+	ChangesRequested bool     `json:"changes_requested"`
+	// CIStatus is one of success, failure, pending and none, only filled with
+	// CI_STATUS
+	CIStatus string `json:"ci_status,omitempty"`
+	// Owners are the CODEOWNERS of the changed files, only filled with
+	// CODEOWNERS
+	Owners []string `json:"owners,omitempty"`
+	// The stack fields are only set for the pull requests based on the
+	// branch of another one, and for the bottom ones of these stacks:
+	// StackParent is the pull request of the base branch, StackRoot the
+	// bottom one, Trunk and TrunkBehindBy its base branch and how far
+	// behind it is, and StackBehindBy adds up BehindBy down the stack
+	StackParent   int    `json:"stack_parent,omitempty"`
+	StackRoot     int    `json:"stack_root,omitempty"`
+	Trunk         string `json:"trunk,omitempty"`
+	TrunkBehindBy int    `json:"trunk_behind_by,omitempty"`
+	StackBehindBy int    `json:"stack_behind_by,omitempty"`
+}
+
+type Report struct {
+	Repo               string      `json:"repo"`
+	GeneratedAt        time.Time   `json:"generated_at"`
+	ConflictsPredicted bool        `json:"conflicts_predicted"`
+	ReviewsFetched     bool        `json:"reviews_fetched"`
+	CIFetched          bool        `json:"ci_fetched"`
+	OwnersFetched      bool        `json:"owners_fetched"`
+	Rows               []ReportRow `json:"rows"`
+}
+
+// ReportSource lists the open pull requests through the configured
+// DATA_SOURCE, together with the backend able to compare them. Every
+// backend compares them with the current tip of their base branch.
+func ReportSource(app *AppMutex, provider Provider) (PullRequestList, CommitBackend, error) {
+	if app.Config.DataSource != "graphql" || app.Config.Provider != "github" {
+		pullRequests, err := provider.ListChangeRequests()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := resolveBaseTips(provider, pullRequests); err != nil {
+			return nil, nil, err
+		}
+		backend, err := NewCommitBackend(app, provider)
+		return pullRequests, backend, err
+	}
+
+	nodes, err := app.RetrievePullRequestsGraphql()
+	if err != nil {
+		return nil, nil, err
+	}
+	pullRequests := PullRequestList{}
+	for _, node := range nodes {
+		pullRequests = append(pullRequests, node.ToPullRequest())
+	}
+	var backend CommitBackend
+	if app.Config.LocalGitDir != "" {
+		if backend, err = NewCommitBackend(app, provider); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		comparisons, err := app.CompareGraphql(nodes)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend = NewGraphqlBackend(app, nodes, comparisons)
+	}
+	// the tips of the compared base branches are cached by the backend
+	if err := resolveBaseTips(app, pullRequests); err != nil {
+		return nil, nil, err
+	}
+	return pullRequests, backend, nil
+}
+
+// resolveBaseTips sets the base of every pull request to the current tip of
+// its base branch, read once per branch, rather than the base commit the
+// forge recorded when the pull request was last updated. The rows updated
+// from the webhooks are compared the same way.
+func resolveBaseTips(provider Provider, pullRequests PullRequestList) error {
+	tips := make(map[string]string)
+	for i := range pullRequests {
+		base := pullRequests[i].Base.Ref
+		tip, found := tips[base]
+		if !found {
+			var err error
+			if tip, err = provider.BranchHead(base); err != nil {
+				return errors.Wrap(err, "resolveBaseTips")
+			}
+			tips[base] = tip
+		}
+		pullRequests[i].Base.Sha = tip
+	}
+	return nil
+}
+
+// NewCommitBackend uses the local clone when LOCAL_GIT_DIR is set, and falls
+// back to one compare API call per pull request otherwise
+func NewCommitBackend(app *AppMutex, provider Provider) (CommitBackend, error) {
+	if app.Config.LocalGitDir == "" {
+		return provider, nil
+	}
+	localGit := NewLocalGit(app.Config.LocalGitDir, app.Config.LocalGitRemote)
+	switch app.Config.Provider {
+	case "gitlab":
+		localGit.PullRefs = "refs/merge-requests/*/head"
+	case "bitbucket":
+		localGit.PullRefs = "refs/pull-requests/*/from"
+	}
+	slog.Info("Fetching the pull request refs", "dir", localGit.Dir)
+	if err := localGit.FetchPullRequests(); err != nil {
+		return nil, err
+	}
+	return localGit, nil
+}
+
+func labelNames(labels []GithubLabel) []string {
+	names := []string{}
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	return names
+}
+
+// BuildReport compares every pull request concurrently, rows keep the order
+// of the pull requests
+func BuildReport(repo string, pullRequests PullRequestList, backend CommitBackend) (*Report, error) {
+	rows := make([]ReportRow, len(pullRequests))
+	eg := errgroup.Group{}
+	for i, pr := range pullRequests {
+		i, pr := i, pr
+		headSha, err := backend.PullRequestHead(pr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "buildReport #%d", pr.Number)
+		}
+		eg.Go(func() error {
+			compareCommit, err := backend.CompareCommits(pr.Base.Sha, headSha)
+			if err != nil {
+				return err
+			}
+			rows[i] = ReportRow{
+				Repo:         repo,
+				Number:       pr.Number,
+				Title:        pr.Title,
+				Author:       pr.User.Login,
+				HTMLURL:      pr.HTMLURL,
+				HeadRef:      pr.Head.Ref,
+				HeadSha:      headSha,
+				BaseRef:      pr.Base.Ref,
+				BaseSha:      pr.Base.Sha,
+				Status:       compareCommit.Status,
+				AheadBy:      compareCommit.AheadBy,
+				BehindBy:     compareCommit.BehindBy,
+				TotalCommits: compareCommit.TotalCommits,
+				CreatedAt:    pr.CreatedAt,
+
+				Labels:           labelNames(pr.Labels),
+				Fork:             pr.FromFork(),
+				Milestone:        pr.Milestone.Title,
+				MilestoneDueOn:   pr.Milestone.DueOn,
+				PendingReviewers: pendingReviewers(repo, pr),
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return &Report{Repo: repo, GeneratedAt: time.Now(), Rows: AnnotateStacks(rows)}, nil
+}
+
+// GenerateReport runs the whole analysis for the repository of the config,
+// with a fresh app so that no branch head is served from a previous run
+func GenerateReport(config *Config) (*Report, error) {
+	app := MakeAppWithDefaults()
+	app.Config = config
+	provider, err := NewProvider(&app)
+	if err != nil {
+		return nil, err
+	}
+	pullRequests, backend, err := ReportSource(&app, provider)
+	if err != nil {
+		return nil, err
+	}
+	report, err := BuildReport(config.Repo(), pullRequests, backend)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.AddDetails(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// AddDetails fills the optional columns enabled in the config, which are
+// only supported on Github
+func (a *AppMutex) AddDetails(report *Report) error {
+	config := a.Config
+	if config.Provider != "github" {
+		return nil
+	}
+	if config.PredictConflicts {
+		if err := a.AddConflictPredictions(report); err != nil {
+			return err
+		}
+	}
+	if config.ReviewStatus {
+		if err := a.AddReviewStatus(report); err != nil {
+			return err
+		}
+	}
+	if config.CIStatus {
+		if err := a.AddCIStatus(report); err != nil {
+			return err
+		}
+	}
+	if config.CompareDetails {
+		if err := a.AddCompareDetails(report); err != nil {
+			return err
+		}
+	}
+	if config.Codeowners {
+		codeowners, err := a.LoadCodeowners(config.CodeownersPath)
+		if err != nil {
+			return err
+		}
+		if err := a.AddOwners(report, codeowners); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PullRequest rebuilds the fields of the pull request BuildReport relies on
+func (row ReportRow) PullRequest() GithubPullRequest {
+	pr := GithubPullRequest{
+		Number:    row.Number,
+		Title:     row.Title,
+		HTMLURL:   row.HTMLURL,
+		CreatedAt: row.CreatedAt,
+		User:      GithubUser{Login: row.Author},
+	}
+	pr.Head.Ref = row.HeadRef
+	pr.Head.Sha = row.HeadSha
+	pr.Base.Ref = row.BaseRef
+	pr.Base.Sha = row.BaseSha
+	// only whether the head repository is the base one is kept, an unknown
+	// head repository is another one
+	pr.Base.Repo.FullName = row.Repo
+	if !row.Fork {
+		pr.Head.Repo.FullName = row.Repo
+	}
+	for _, label := range row.Labels {
+		pr.Labels = append(pr.Labels, GithubLabel{Name: label})
+	}
+	pr.Milestone.Title = row.Milestone
+	pr.Milestone.DueOn = row.MilestoneDueOn
+	for _, reviewer := range row.PendingReviewers {
+		if i := strings.Index(reviewer, "/"); i >= 0 {
+			pr.RequestedTeams = append(pr.RequestedTeams, GithubTeam{Slug: reviewer[i+1:]})
+		} else {
+			pr.RequestedReviewers = append(pr.RequestedReviewers, GithubUser{Login: reviewer})
+		}
+	}
+	return pr
+}
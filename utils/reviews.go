@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// pendingReviewers lists the requested reviewers who have not reviewed yet,
+// teams being written "org/slug"
+func pendingReviewers(repo string, pr GithubPullRequest) []string {
+	org := strings.SplitN(repo, "/", 2)[0]
+	pending := []string{}
+	for _, user := range pr.RequestedReviewers {
+		pending = append(pending, user.Login)
+	}
+	for _, team := range pr.RequestedTeams {
+		pending = append(pending, org+"/"+team.Slug)
+	}
+	return pending
+}
+
+// reviewState keeps the latest approving or blocking review of every
+// reviewer, as Github does: a comment does not withdraw an approval, a
+// dismissal does
+func reviewState(reviews []GithubReview) (approvals int, changesRequested bool) {
+	latest := make(map[string]string)
+	for _, review := range reviews {
+		switch review.State {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+			latest[review.User.Login] = review.State
+		}
+	}
+	for _, state := range latest {
+		switch state {
+		case "APPROVED":
+			approvals++
+		case "CHANGES_REQUESTED":
+			changesRequested = true
+		}
+	}
+	return approvals, changesRequested
+}
+
+// AddReviewStatus costs one reviews call per pull request
+func (a *AppMutex) AddReviewStatus(report *Report) error {
+	eg := errgroup.Group{}
+	eg.SetLimit(maxConcurrentRequests)
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		eg.Go(func() error {
+			reviews, err := a.RequestReviews(row.Number)
+			if err != nil {
+				return err
+			}
+			row.Approvals, row.ChangesRequested = reviewState(reviews)
+			return nil
+		})
+	}
+	report.ReviewsFetched = true
+	return eg.Wait()
+}
+
+// ReviewSummary is the review column of the table
+func (row ReportRow) ReviewSummary() string {
+	parts := []string{strconv.Itoa(row.Approvals) + " approved"}
+	if row.ChangesRequested {
+		parts = append(parts, "changes requested")
+	}
+	if len(row.PendingReviewers) > 0 {
+		parts = append(parts, "waiting on "+strings.Join(row.PendingReviewers, " "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ApprovedButBehind tells the pull requests which only need a rebase to
+// ship
+func (row ReportRow) ApprovedButBehind() bool {
+	return row.Approvals > 0 && !row.ChangesRequested && row.BehindBy > 0
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Snapshot keeps the latest reports of every repository in memory and
+// refreshes them on an interval or on demand. The row updates made while a
+// refresh runs are pending, to be applied again on top of its reports.
+type Snapshot struct {
+	lock          sync.RWMutex
+	reports       []*Report
+	branchReports []*BranchReport
+	errors        map[string]string
+	refreshedAt   time.Time
+	refresh       chan struct{}
+	pending       map[string][]rowUpdate
+	Config        *Config
+	Interval      time.Duration
+}
+
+func NewSnapshot(config *Config) *Snapshot {
+	return &Snapshot{
+		errors:   make(map[string]string),
+		refresh:  make(chan struct{}, 1),
+		Config:   config,
+		Interval: config.ServeInterval(),
+	}
+}
+
+// rowUpdate changes the rows of a report, such as from a webhook event
+type rowUpdate func([]ReportRow) []ReportRow
+
+// Refresh regenerates the reports of every repository, the previous reports
+// of a repository are kept when its analysis fails. The updates received
+// from the webhooks in the meantime are kept too.
+func (s *Snapshot) Refresh() {
+	s.lock.Lock()
+	s.pending = make(map[string][]rowUpdate)
+	s.lock.Unlock()
+
+	reports := []*Report{}
+	branchReports := []*BranchReport{}
+	failures := make(map[string]string)
+	for _, repo := range s.Config.AllRepos() {
+		config := s.Config.ForRepo(repo)
+		startedAt := time.Now()
+		report, err := GenerateReport(&config)
+		if err != nil {
+			slog.Warn("Could not refresh", "repo", repo, "error", err)
+			failures[repo] = err.Error()
+			report = s.Report(repo)
+		}
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err == nil && s.Config.HistoryEnabled() {
+			if err := (HistoryStore{Path: s.Config.HistoryPath}).Append(report); err != nil {
+				slog.Warn("Could not record the history", "repo", repo, "error", err)
+			}
+		}
+
+		if s.Config.ServeBranches {
+			branchReport, err := GenerateBranchReport(&config)
+			if err != nil {
+				slog.Warn("Could not refresh the branches", "repo", repo, "error", err)
+				failures[repo] = err.Error()
+				branchReport = s.BranchReport(repo)
+			}
+			if branchReport != nil {
+				branchReports = append(branchReports, branchReport)
+			}
+		}
+		AppMetrics.ObserveScan(repo, time.Since(startedAt))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, report := range reports {
+		if updates := s.pending[report.Repo]; len(updates) > 0 {
+			reports[i] = report.withRows(updates...)
+		}
+	}
+	s.pending = nil
+	s.reports = reports
+	s.branchReports = branchReports
+	s.errors = failures
+	s.refreshedAt = time.Now()
+}
+
+func (s *Snapshot) Report(repo string) *Report {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, report := range s.reports {
+		if report.Repo == repo {
+			return report
+		}
+	}
+	return nil
+}
+
+func (s *Snapshot) BranchReport(repo string) *BranchReport {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, report := range s.branchReports {
+		if report.Repo == repo {
+			return report
+		}
+	}
+	return nil
+}
+
+func (s *Snapshot) Reports() []*Report {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.reports
+}
+
+func (s *Snapshot) Errors() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.errors
+}
+
+func (s *Snapshot) RefreshedAt() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.refreshedAt
+}
+
+// RequestRefresh schedules a refresh, requests made while one is already
+// pending are merged
+func (s *Snapshot) RequestRefresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Run refreshes the reports until the process exits
+func (s *Snapshot) Run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.Refresh()
+		slog.Info("Snapshot refreshed")
+		select {
+		case <-ticker.C:
+		case <-s.refresh:
+		}
+	}
+}
+
+// PullRequestsTargeting rebuilds from the report the pull requests whose base
+// is the given branch
+func (s *Snapshot) PullRequestsTargeting(repo string, base string) PullRequestList {
+	pullRequests := PullRequestList{}
+	report := s.Report(repo)
+	if report == nil {
+		return pullRequests
+	}
+	for _, row := range report.Rows {
+		if row.BaseRef == base {
+			pullRequests = append(pullRequests, row.PullRequest())
+		}
+	}
+	return pullRequests
+}
+
+// UpdatePullRequests compares the given pull requests only and merges them
+// into the report of the repository. Like on a refresh, they are compared
+// with the current tip of their base rather than the base in the event.
+func (s *Snapshot) UpdatePullRequests(repo string, pullRequests PullRequestList) error {
+	config := s.Config.ForRepo(repo)
+	app := MakeAppWithDefaults()
+	app.Config = &config
+	provider, err := NewProvider(&app)
+	if err != nil {
+		return err
+	}
+	if err := resolveBaseTips(provider, pullRequests); err != nil {
+		return err
+	}
+	backend, err := NewCommitBackend(&app, provider)
+	if err != nil {
+		return err
+	}
+	partial, err := BuildReport(repo, pullRequests, backend)
+	if err != nil {
+		return err
+	}
+	if err := app.AddDetails(partial); err != nil {
+		return err
+	}
+
+	s.updateRows(repo, func(rows []ReportRow) []ReportRow {
+		updated := make(map[int]ReportRow)
+		for _, row := range partial.Rows {
+			updated[row.Number] = row
+		}
+		for i, row := range rows {
+			if u, found := updated[row.Number]; found {
+				rows[i] = u
+				delete(updated, row.Number)
+			}
+		}
+		for _, row := range partial.Rows {
+			if _, found := updated[row.Number]; found {
+				rows = append(rows, row)
+			}
+		}
+		return rows
+	})
+	return nil
+}
+
+func (s *Snapshot) RemovePullRequest(repo string, number int) {
+	s.updateRows(repo, func(rows []ReportRow) []ReportRow {
+		kept := []ReportRow{}
+		for _, row := range rows {
+			if row.Number != number {
+				kept = append(kept, row)
+			}
+		}
+		return kept
+	})
+}
+
+// withRows returns an updated copy of the report, the reports already handed
+// out to readers are never modified
+func (report *Report) withRows(updates ...rowUpdate) *Report {
+	updated := *report
+	updated.GeneratedAt = time.Now()
+	rows := append([]ReportRow{}, report.Rows...)
+	for _, update := range updates {
+		rows = update(rows)
+	}
+	updated.Rows = AnnotateStacks(rows)
+	return &updated
+}
+
+// updateRows replaces the report of the repository with an updated copy, and
+// keeps the update for the refresh running, if any
+func (s *Snapshot) updateRows(repo string, update rowUpdate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pending != nil {
+		s.pending[repo] = append(s.pending[repo], update)
+	}
+	reports := append([]*Report{}, s.reports...)
+	for i, report := range reports {
+		if report.Repo == repo {
+			reports[i] = report.withRows(update)
+			s.reports = reports
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import "fmt"
+
+// StackNode is a pull request of a stack with the pull requests based on its
+// branch
+type StackNode struct {
+	Row      ReportRow    `json:"row"`
+	Children []*StackNode `json:"children"`
+}
+
+// stackParents maps every row to the row whose head branch is its base, -1
+// for the rows based on a trunk branch. Only the head branches of the
+// repository itself are bases: the pull requests from forks are never
+// parents, even with a head branch named like a trunk branch, such as the
+// master of a fork. The branch names shared by several pull requests are
+// ambiguous and treated as trunks.
+func stackParents(rows []ReportRow) []int {
+	heads := make(map[string][]int)
+	for i, row := range rows {
+		if !row.Fork {
+			heads[row.HeadRef] = append(heads[row.HeadRef], i)
+		}
+	}
+	parents := make([]int, len(rows))
+	for i, row := range rows {
+		parents[i] = -1
+		if candidates := heads[row.BaseRef]; len(candidates) == 1 && candidates[0] != i {
+			parents[i] = candidates[0]
+		}
+	}
+	// a cycle has no trunk, cut it where it is found
+	for i := range rows {
+		seen := map[int]bool{i: true}
+		for j := parents[i]; j != -1; j = parents[j] {
+			if seen[j] {
+				parents[j] = -1
+				break
+			}
+			seen[j] = true
+		}
+	}
+	return parents
+}
+
+// AnnotateStacks fills the stack fields of the rows based on the head branch
+// of another pull request, and of the bottom pull requests of the stacks.
+// The distance of the bottom pull request from the trunk is propagated up
+// the stack, as every pull request above it is that far behind too.
+func AnnotateStacks(rows []ReportRow) []ReportRow {
+	parents := stackParents(rows)
+	stacked := make([]bool, len(rows))
+	for i, parent := range parents {
+		if parent != -1 {
+			stacked[i], stacked[parent] = true, true
+		}
+	}
+	for i := range rows {
+		row := &rows[i]
+		row.StackParent, row.StackRoot, row.Trunk, row.TrunkBehindBy, row.StackBehindBy = 0, 0, "", 0, 0
+		if !stacked[i] {
+			continue
+		}
+		root := i
+		row.StackBehindBy = row.BehindBy
+		for parents[root] != -1 {
+			root = parents[root]
+			row.StackBehindBy += rows[root].BehindBy
+		}
+		if parents[i] != -1 {
+			row.StackParent = rows[parents[i]].Number
+		}
+		row.StackRoot = rows[root].Number
+		row.Trunk = rows[root].BaseRef
+		row.TrunkBehindBy = rows[root].BehindBy
+	}
+	return rows
+}
+
+// Stacks returns the trees of the stacked pull requests, rooted on the
+// bottom pull request of every stack
+func Stacks(rows []ReportRow) []*StackNode {
+	parents := stackParents(rows)
+	nodes := make([]*StackNode, len(rows))
+	for i, row := range rows {
+		nodes[i] = &StackNode{Row: row, Children: []*StackNode{}}
+	}
+	roots := []*StackNode{}
+	for i, parent := range parents {
+		if parent != -1 {
+			nodes[parent].Children = append(nodes[parent].Children, nodes[i])
+		}
+	}
+	for i, parent := range parents {
+		if parent == -1 && len(nodes[i].Children) > 0 {
+			roots = append(roots, nodes[i])
+		}
+	}
+	return roots
+}
+
+// StackSummary is the stack column of the table
+func (row ReportRow) StackSummary() string {
+	if row.StackRoot == 0 {
+		return ""
+	}
+	position := "bottom"
+	if row.StackParent != 0 {
+		position = fmt.Sprintf("on #%d", row.StackParent)
+	}
+	return fmt.Sprintf("%s, %d behind %s through the stack", position, row.StackBehindBy, row.Trunk)
+}
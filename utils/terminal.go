@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Terminal is what the TUI runs on: the real terminal opened by OpenTTY, or
+// any reader and writer simulating one
+type Terminal struct {
+	In  *bufio.Reader
+	Out io.Writer
+	// Size returns the current width and height, in characters
+	Size func() (int, int)
+}
+
+// ReadKey decodes the next key pressed: the special keys are named ("up",
+// "down", "left", "right", "pgup", "pgdown", "home", "end", "enter", "esc",
+// "tab", "backspace" and "ctrl-c"), the others are returned as typed
+func ReadKey(in *bufio.Reader) (string, error) {
+	b, err := in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case '\r', '\n':
+		return "enter", nil
+	case '\t':
+		return "tab", nil
+	case 0x7f, 0x08:
+		return "backspace", nil
+	case 0x03:
+		return "ctrl-c", nil
+	case 0x1b:
+		return readEscape(in)
+	}
+	if b < 0x80 {
+		return string(b), nil
+	}
+	in.UnreadByte()
+	r, _, err := in.ReadRune()
+	return string(r), err
+}
+
+// readEscape tells a lone escape key from an escape sequence by whether the
+// rest of the sequence came with it
+func readEscape(in *bufio.Reader) (string, error) {
+	if in.Buffered() == 0 {
+		return "esc", nil
+	}
+	b, err := in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return "esc", err
+	}
+	// CSI parameters, such as the modifiers of "1;5A" (ctrl-up), are read
+	// up to the final byte of the sequence and the modifiers are ignored
+	params := ""
+	for {
+		b, err = in.ReadByte()
+		if err != nil {
+			return "esc", err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+		if b < 0x20 || b > 0x7e {
+			// not a CSI sequence, the byte is the next key
+			in.UnreadByte()
+			return "esc", nil
+		}
+		params += string(b)
+	}
+	switch b {
+	case 'A':
+		return "up", nil
+	case 'B':
+		return "down", nil
+	case 'C':
+		return "right", nil
+	case 'D':
+		return "left", nil
+	case 'H':
+		return "home", nil
+	case 'F':
+		return "end", nil
+	case '~':
+		switch strings.SplitN(params, ";", 2)[0] {
+		case "1", "7":
+			return "home", nil
+		case "4", "8":
+			return "end", nil
+		case "5":
+			return "pgup", nil
+		case "6":
+			return "pgdown", nil
+		}
+	}
+	return "esc", nil
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// OpenTTY switches the controlling terminal to raw mode and to the alternate
+// screen through stty, which also works over SSH. The returned function
+// restores the terminal.
+func OpenTTY() (*Terminal, func(), error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "openTTY")
+	}
+	state, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, nil, errors.Wrap(err, "openTTY: stty")
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		tty.Close()
+		return nil, nil, errors.Wrap(err, "openTTY: stty")
+	}
+	io.WriteString(tty, "\x1b[?1049h\x1b[?25l")
+
+	size := func() (int, int) {
+		// stty reports "0 0" on the ptys without a size, such as some SSH
+		// sessions
+		width, height := 80, 24
+		out, err := stty(tty, "size")
+		if fields := strings.Fields(out); err == nil && len(fields) == 2 {
+			if h, _ := strconv.Atoi(fields[0]); h > 0 {
+				height = h
+			}
+			if w, _ := strconv.Atoi(fields[1]); w > 0 {
+				width = w
+			}
+		}
+		return width, height
+	}
+	restore := func() {
+		io.WriteString(tty, "\x1b[?25h\x1b[?1049l")
+		stty(tty, state)
+		tty.Close()
+	}
+	return &Terminal{In: bufio.NewReader(tty), Out: tty, Size: size}, restore, nil
+}
@@ -0,0 +1,451 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+var tuiSorts = []struct {
+	name string
+	less func(x, y ReportRow) bool
+}{
+	{"behind", func(x, y ReportRow) bool { return x.BehindBy > y.BehindBy }},
+	{"ahead", func(x, y ReportRow) bool { return x.AheadBy > y.AheadBy }},
+	{"number", func(x, y ReportRow) bool { return x.Number < y.Number }},
+	{"author", func(x, y ReportRow) bool { return x.Author < y.Author }},
+	{"created", func(x, y ReportRow) bool { return x.CreatedAt.Before(y.CreatedAt) }},
+}
+
+const tuiHelp = "j/k move  / filter  s sort  r reverse  enter commits  o open  c copy branch  l label  m comment  q quit"
+
+// detailHeight is the number of lines of the detail pane
+const detailHeight = 10
+
+// TUI browses a report in a terminal. The state only changes through
+// HandleKey and is drawn by Render, so that it can be driven by a simulated
+// terminal as well as by Run.
+type TUI struct {
+	Report *Report
+	Config *Config
+	// Provider runs the label and comment actions, which are disabled when
+	// it is nil
+	Provider Provider
+	// Open opens a URL in a browser, the URL is shown instead when it fails
+	Open func(url string) error
+	// MissingCommits loads the base commits missing from a pull request, when
+	// they are not in the report already
+	MissingCommits func(row ReportRow) ([]GithubCommit, error)
+
+	rows    []ReportRow
+	cursor  int
+	offset  int
+	sortBy  int
+	reverse bool
+	filter  string
+	editing bool
+	// pending is the action waiting for a confirmation, "label" or "comment"
+	pending string
+	status  string
+	// clipboard is sent to the terminal on the next render
+	clipboard string
+	missing   map[int][]GithubCommit
+	quit      bool
+}
+
+func NewTUI(report *Report, config *Config, provider Provider) *TUI {
+	t := &TUI{
+		Report:   report,
+		Config:   config,
+		Provider: provider,
+		Open:     OpenBrowser,
+		missing:  make(map[int][]GithubCommit),
+	}
+	t.refresh()
+	return t
+}
+
+// OpenBrowser refuses to open a browser over SSH, as it would open on the
+// remote host
+func OpenBrowser(url string) error {
+	if os.Getenv("SSH_CONNECTION") != "" {
+		return errors.New("no browser over SSH")
+	}
+	command := "xdg-open"
+	switch runtime.GOOS {
+	case "darwin":
+		command = "open"
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	}
+	return exec.Command(command, url).Start()
+}
+
+func (t *TUI) Quit() bool {
+	return t.quit
+}
+
+// Rows returns the pull requests shown, filtered and sorted
+func (t *TUI) Rows() []ReportRow {
+	return t.rows
+}
+
+// Selected returns the pull request under the cursor
+func (t *TUI) Selected() (ReportRow, bool) {
+	if t.cursor >= len(t.rows) {
+		return ReportRow{}, false
+	}
+	return t.rows[t.cursor], true
+}
+
+func (t *TUI) matches(row ReportRow) bool {
+	if t.filter == "" {
+		return true
+	}
+	text := strings.Join(append([]string{"#" + strconv.Itoa(row.Number), row.Title, row.Author, row.HeadRef, row.BaseRef}, row.Labels...), " ")
+	return strings.Contains(strings.ToLower(text), strings.ToLower(t.filter))
+}
+
+// refresh filters and sorts the rows again, keeping the selection when the
+// selected pull request is still shown
+func (t *TUI) refresh() {
+	selected, found := t.Selected()
+	rows := []ReportRow{}
+	for _, row := range t.Report.Rows {
+		if t.matches(row) {
+			rows = append(rows, row)
+		}
+	}
+	less := tuiSorts[t.sortBy].less
+	sort.SliceStable(rows, func(i, j int) bool {
+		if t.reverse {
+			return less(rows[j], rows[i])
+		}
+		return less(rows[i], rows[j])
+	})
+	t.rows = rows
+	t.cursor = 0
+	for i, row := range rows {
+		if found && row.Number == selected.Number {
+			t.cursor = i
+		}
+	}
+}
+
+func (t *TUI) move(delta int) {
+	t.cursor += delta
+	if t.cursor >= len(t.rows) {
+		t.cursor = len(t.rows) - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+}
+
+// HandleKey applies a key as decoded by ReadKey
+func (t *TUI) HandleKey(key string) {
+	if t.editing {
+		t.editFilter(key)
+		return
+	}
+	if t.pending != "" {
+		t.confirm(key == "y")
+		return
+	}
+	t.status = ""
+	switch key {
+	case "q", "ctrl-c":
+		t.quit = true
+	case "down", "j":
+		t.move(1)
+	case "up", "k":
+		t.move(-1)
+	case "pgdown", " ":
+		t.move(10)
+	case "pgup":
+		t.move(-10)
+	case "home", "g":
+		t.move(-len(t.rows))
+	case "end", "G":
+		t.move(len(t.rows))
+	case "/":
+		t.editing = true
+	case "esc":
+		t.filter = ""
+		t.refresh()
+	case "s":
+		t.sortBy = (t.sortBy + 1) % len(tuiSorts)
+		t.refresh()
+	case "r":
+		t.reverse = !t.reverse
+		t.refresh()
+	case "enter":
+		t.loadMissingCommits()
+	case "o":
+		t.open()
+	case "c":
+		if row, found := t.Selected(); found {
+			t.clipboard = row.HeadRef
+			t.status = "Copied " + row.HeadRef
+		}
+	case "l", "m":
+		t.requestAction(key)
+	}
+}
+
+func (t *TUI) editFilter(key string) {
+	switch key {
+	case "enter":
+		t.editing = false
+	case "esc":
+		t.editing = false
+		t.filter = ""
+	case "backspace":
+		if runes := []rune(t.filter); len(runes) > 0 {
+			t.filter = string(runes[:len(runes)-1])
+		}
+	default:
+		if len([]rune(key)) == 1 {
+			t.filter += key
+		}
+	}
+	t.refresh()
+}
+
+func (t *TUI) open() {
+	row, found := t.Selected()
+	if !found {
+		return
+	}
+	if t.Open == nil || t.Open(row.HTMLURL) != nil {
+		t.status = row.HTMLURL
+		return
+	}
+	t.status = "Opened " + row.HTMLURL
+}
+
+func (t *TUI) loadMissingCommits() {
+	row, found := t.Selected()
+	if !found || row.MissingCommits != nil || t.MissingCommits == nil {
+		return
+	}
+	commits, err := t.MissingCommits(row)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.missing[row.Number] = commits
+}
+
+func (t *TUI) requestAction(key string) {
+	row, found := t.Selected()
+	if !found {
+		return
+	}
+	if t.Provider == nil {
+		t.status = "Actions are not available"
+		return
+	}
+	if key == "l" {
+		t.pending = "label"
+		t.status = fmt.Sprintf("Label #%d with %s? [y/N]", row.Number, strings.Join(t.Config.ActionLabels, ", "))
+	} else {
+		t.pending = "comment"
+		t.status = fmt.Sprintf("Comment on #%d: %q? [y/N]", row.Number, ActionComment(t.Config.ActionComment, row))
+	}
+}
+
+func (t *TUI) confirm(confirmed bool) {
+	action := t.pending
+	t.pending = ""
+	row, found := t.Selected()
+	if !confirmed || !found {
+		t.status = "Cancelled"
+		return
+	}
+	var err error
+	if action == "label" {
+		err = LabelPullRequest(t.Provider, t.Config, row)
+	} else {
+		err = CommentPullRequest(t.Provider, t.Config, row)
+	}
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.status = fmt.Sprintf("Done: %s on #%d", action, row.Number)
+}
+
+func truncate(line string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	if runes := []rune(line); len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+// printable drops the C0 and C1 control characters, such as the escape
+// sequences a title or a commit message could carry to the terminal
+func printable(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func shortSha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func (t *TUI) detail(row ReportRow) []string {
+	lines := []string{
+		fmt.Sprintf("#%d %s", row.Number, row.Title),
+		fmt.Sprintf("by %s, %s -> %s, created %s", row.Author, row.HeadRef, row.BaseRef, row.CreatedAt.Format("2006-01-02")),
+		row.HTMLURL,
+		"Labels: " + strings.Join(row.Labels, ", "),
+		fmt.Sprintf("%d ahead, %d behind (%s)", row.AheadBy, row.BehindBy, row.Status),
+	}
+	if row.StackRoot != 0 {
+		lines[4] += ", stack: " + row.StackSummary()
+	}
+	commits := row.MissingCommits
+	if commits == nil {
+		commits = t.missing[row.Number]
+	}
+	switch {
+	case row.BehindBy == 0:
+		lines = append(lines, "No missing commits")
+	case commits == nil && t.MissingCommits == nil:
+		lines = append(lines, fmt.Sprintf("%d missing commits", row.BehindBy))
+	case commits == nil:
+		lines = append(lines, fmt.Sprintf("%d missing commits, press enter to list them", row.BehindBy))
+	default:
+		lines = append(lines, "Missing commits:")
+		for _, commit := range commits {
+			lines = append(lines, "  "+shortSha(commit.Sha)+" "+strings.SplitN(commit.Commit.Message, "\n", 2)[0])
+		}
+	}
+	for i := range lines {
+		lines[i] = printable(lines[i])
+	}
+	return lines
+}
+
+// Render draws the whole screen: the list of pull requests, the detail pane
+// of the selected one and the status line
+func (t *TUI) Render(w io.Writer, width int, height int) error {
+	if width < 1 {
+		width = 1
+	}
+	lines := []string{}
+	header := fmt.Sprintf("%s: %d/%d PRs, sorted by %s", t.Report.Repo, len(t.rows), len(t.Report.Rows), tuiSorts[t.sortBy].name)
+	if t.reverse {
+		header += " (reversed)"
+	}
+	if t.filter != "" || t.editing {
+		header += ", filter: " + t.filter
+		if t.editing {
+			header += "_"
+		}
+	}
+	lines = append(lines, header, fmt.Sprintf("  %-6s %6s %6s  %-15s %-25s %s", "PR", "Behind", "Ahead", "Author", "Branch", "Title"))
+
+	listHeight := height - detailHeight - 4
+	if listHeight < 1 {
+		listHeight = 1
+	}
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+	if t.cursor >= t.offset+listHeight {
+		t.offset = t.cursor - listHeight + 1
+	}
+	for i := t.offset; i < t.offset+listHeight; i++ {
+		if i >= len(t.rows) {
+			lines = append(lines, "")
+			continue
+		}
+		row := t.rows[i]
+		line := fmt.Sprintf("  %-6s %6d %6d  %-15s %-25s %s", "#"+strconv.Itoa(row.Number), row.BehindBy, row.AheadBy, truncate(printable(row.Author), 15), truncate(printable(row.HeadRef), 25), printable(row.Title))
+		if i == t.cursor {
+			line = "\x1b[7m>" + truncate(line[1:], width-1) + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+
+	lines = append(lines, strings.Repeat("-", width))
+	detail := []string{}
+	if row, found := t.Selected(); found {
+		detail = t.detail(row)
+	}
+	for i := 0; i < detailHeight; i++ {
+		if i < len(detail) {
+			lines = append(lines, detail[i])
+		} else {
+			lines = append(lines, "")
+		}
+	}
+	status := printable(t.status)
+	if status == "" {
+		status = tuiHelp
+	}
+	lines = append(lines, status)
+
+	screen := strings.Builder{}
+	screen.WriteString("\x1b[H\x1b[2J")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "\x1b[7m") {
+			line = truncate(line, width)
+		}
+		if i > 0 {
+			screen.WriteString("\r\n")
+		}
+		screen.WriteString(line)
+	}
+	// OSC 52 sets the clipboard of the terminal emulator, on the local
+	// machine even over SSH
+	if t.clipboard != "" {
+		screen.WriteString("\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(t.clipboard)) + "\a")
+		t.clipboard = ""
+	}
+	_, err := io.WriteString(w, screen.String())
+	return err
+}
+
+// Run draws the screen and handles the keys until the user quits or the
+// input ends
+func (t *TUI) Run(term *Terminal) error {
+	for {
+		width, height := term.Size()
+		if err := t.Render(term.Out, width, height); err != nil {
+			return err
+		}
+		if t.quit {
+			return nil
+		}
+		key, err := ReadKey(term.In)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t.HandleKey(key)
+	}
+}
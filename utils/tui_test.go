@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingProvider records the actions of the TUI instead of calling a forge
+type recordingProvider struct {
+	actions []string
+}
+
+func (p *recordingProvider) PullRequestHead(pr GithubPullRequest) (string, error) {
+	return pr.Head.Sha, nil
+}
+
+func (p *recordingProvider) CompareCommits(baseSha string, headSha string) (*GithubCommitCompare, error) {
+	return &GithubCommitCompare{}, nil
+}
+
+func (p *recordingProvider) ListChangeRequests() (PullRequestList, error) {
+	return PullRequestList{}, nil
+}
+
+func (p *recordingProvider) BranchHead(branch string) (string, error) {
+	return "", nil
+}
+
+func (p *recordingProvider) AddLabels(number int, labels []string) error {
+	p.actions = append(p.actions, fmt.Sprintf("label #%d %s", number, strings.Join(labels, ",")))
+	return nil
+}
+
+func (p *recordingProvider) AddComment(number int, comment string) error {
+	p.actions = append(p.actions, fmt.Sprintf("comment #%d %s", number, comment))
+	return nil
+}
+
+func tuiReport() *Report {
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	return &Report{
+		Repo: "octo/repo",
+		Rows: []ReportRow{
+			{Number: 1, Title: "Small fix", Author: "alice", HeadRef: "fix", BaseRef: "master", BehindBy: 2, AheadBy: 1, CreatedAt: created},
+			{Number: 2, Title: "Big feature", Author: "bob", HeadRef: "feature", BaseRef: "master", BehindBy: 9, AheadBy: 30, CreatedAt: created},
+			{Number: 3, Title: "Docs", Author: "carol", HeadRef: "docs", BaseRef: "master", CreatedAt: created},
+		},
+	}
+}
+
+// simulatedTerminal types the keys, and reports the size of the ptys without
+// one, which stty gives as "0 0"
+func simulatedTerminal(keys string, out io.Writer) *Terminal {
+	return &Terminal{
+		In:   bufio.NewReader(strings.NewReader(keys)),
+		Out:  out,
+		Size: func() (int, int) { return 0, 0 },
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("\x1b[1;5Aj\x1b[5~\x1b[B\x1bOHq\x1b[1;2Fk\x1b[Zx\r\x7fé\x1b"))
+	want := []string{"up", "j", "pgup", "down", "home", "q", "end", "k", "esc", "x", "enter", "backspace", "é", "esc"}
+	for _, w := range want {
+		key, err := ReadKey(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != w {
+			t.Errorf("ReadKey = %q, want %q", key, w)
+		}
+	}
+	if _, err := ReadKey(in); err != io.EOF {
+		t.Errorf("ReadKey at the end = %v, want EOF", err)
+	}
+}
+
+func TestTUIRun(t *testing.T) {
+	tui := NewTUI(tuiReport(), &Config{}, nil)
+	tui.Open = nil
+	if got := tui.Rows(); got[0].Number != 2 || got[1].Number != 1 || got[2].Number != 3 {
+		t.Fatalf("rows not sorted by behind: %+v", got)
+	}
+
+	// filter on alice, move down with ctrl-down, copy the branch and quit
+	out := bytes.Buffer{}
+	if err := tui.Run(simulatedTerminal("/ali\r\x1b[1;5Bcq", &out)); err != nil {
+		t.Fatal(err)
+	}
+	if !tui.Quit() {
+		t.Error("q did not quit")
+	}
+	if row, found := tui.Selected(); !found || row.Number != 1 || len(tui.Rows()) != 1 {
+		t.Errorf("selected %+v among %d rows, want #1 alone", row, len(tui.Rows()))
+	}
+	if !strings.Contains(out.String(), "\x1b]52;c;"+base64.StdEncoding.EncodeToString([]byte("fix"))+"\a") {
+		t.Error("the branch was not copied to the clipboard")
+	}
+}
+
+func TestTUIRender(t *testing.T) {
+	tui := NewTUI(tuiReport(), &Config{}, nil)
+	tui.MissingCommits = func(row ReportRow) ([]GithubCommit, error) {
+		commit := GithubCommit{Sha: "0123456789abcdef"}
+		commit.Commit.Message = "Bump version\n\nlong description"
+		return []GithubCommit{commit}, nil
+	}
+	tui.HandleKey("enter")
+	tui.HandleKey("s")
+
+	out := bytes.Buffer{}
+	if err := tui.Render(&out, 80, 24); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\r\n")
+	if len(lines) != 24 {
+		t.Errorf("%d lines rendered, want 24", len(lines))
+	}
+	screen := out.String()
+	for _, want := range []string{"octo/repo: 3/3 PRs, sorted by ahead", "#2 Big feature", "  0123456 Bump version"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("screen does not contain %q:\n%s", want, screen)
+		}
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "\x1b[7m") && len([]rune(strings.TrimPrefix(line, "\x1b[H\x1b[2J"))) > 80 {
+			t.Errorf("line wider than the terminal: %q", line)
+		}
+	}
+
+	// a terminal without a size still renders
+	for _, size := range [][2]int{{0, 0}, {1, 1}, {-5, 3}} {
+		if err := tui.Render(io.Discard, size[0], size[1]); err != nil {
+			t.Errorf("Render(%d, %d): %v", size[0], size[1], err)
+		}
+	}
+}
+
+func TestTUIRenderStripsControlCharacters(t *testing.T) {
+	commit := GithubCommit{Sha: "0123456789abcdef"}
+	commit.Commit.Message = "Bump\x1b[8m version\x7f"
+	report := &Report{Repo: "octo/repo", Rows: []ReportRow{{
+		Number:         1,
+		Title:          "Fix\x1b]52;c;cm0gLXJm\x07 \u009b2Jthe build",
+		Author:         "mallory\x1b[2J",
+		HeadRef:        "fix\rbranch",
+		BaseRef:        "master\x00",
+		Labels:         []string{"bug\x1b[31m"},
+		BehindBy:       1,
+		MissingCommits: []GithubCommit{commit},
+	}}}
+	tui := NewTUI(report, &Config{}, nil)
+
+	out := bytes.Buffer{}
+	if err := tui.Render(&out, 120, 24); err != nil {
+		t.Fatal(err)
+	}
+	// the escape sequences of the TUI itself are the only ones left
+	screen := strings.NewReplacer("\x1b[H\x1b[2J", "", "\x1b[7m", "", "\x1b[0m", "", "\r\n", "").Replace(out.String())
+	for _, r := range screen {
+		if r < 0x20 || (r >= 0x7f && r <= 0x9f) {
+			t.Fatalf("control character %U rendered:\n%q", r, screen)
+		}
+	}
+	for _, want := range []string{"Fix]52;c;cm0gLXJm 2Jthe build", "by mallory[2J, fixbranch -> master", "Labels: bug[31m", "0123456 Bump[8m version"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("screen does not contain %q:\n%s", want, screen)
+		}
+	}
+}
+
+func TestTUIActions(t *testing.T) {
+	provider := &recordingProvider{}
+	config := &Config{ActionLabels: []string{"outdated"}, ActionComment: "@{author} please rebase on {base}"}
+	tui := NewTUI(tuiReport(), config, provider)
+
+	for _, key := range []string{"l", "y", "j", "m", "n", "m", "y"} {
+		tui.HandleKey(key)
+	}
+	want := []string{"label #2 outdated", "comment #1 @alice please rebase on master"}
+	if strings.Join(provider.actions, "; ") != strings.Join(want, "; ") {
+		t.Errorf("actions %q, want %q", provider.actions, want)
+	}
+
+	tui = NewTUI(tuiReport(), config, nil)
+	tui.HandleKey("l")
+	if tui.status != "Actions are not available" {
+		t.Errorf("status %q without a provider", tui.status)
+	}
+}
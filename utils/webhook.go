@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const webhookMaxPayload = 25 << 20
+
+// https://developer.github.com/webhooks/event-payloads/#push
+type GithubPushEvent struct {
+	Ref        string     `json:"ref"`
+	Before     string     `json:"before"`
+	After      string     `json:"after"`
+	Deleted    bool       `json:"deleted"`
+	Repository GithubRepo `json:"repository"`
+}
+
+// https://developer.github.com/webhooks/event-payloads/#pull_request
+type GithubPullRequestEvent struct {
+	Action      string            `json:"action"`
+	Number      int               `json:"number"`
+	PullRequest GithubPullRequest `json:"pull_request"`
+	Repository  GithubRepo        `json:"repository"`
+}
+
+// WebhookReceiver keeps the snapshot up to date from the Github events, so
+// that only the pull requests affected by an event are compared again
+type WebhookReceiver struct {
+	Snapshot *Snapshot
+	Secret   string
+}
+
+func (wr *WebhookReceiver) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/webhook", wr.serveWebhook)
+}
+
+// VerifySignature checks the X-Hub-Signature-256 header against the HMAC
+// of the payload
+func VerifySignature(secret string, signature string, payload []byte) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// tracked returns the repository as configured, Github names being case
+// insensitive
+func (wr *WebhookReceiver) tracked(repo string) (string, bool) {
+	for _, r := range wr.Snapshot.Config.AllRepos() {
+		if strings.EqualFold(r, repo) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+func (wr *WebhookReceiver) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxPayload))
+	if err != nil {
+		http.Error(w, "could not read payload", http.StatusBadRequest)
+		return
+	}
+	if !VerifySignature(wr.Secret, r.Header.Get("X-Hub-Signature-256"), payload) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "push":
+		event := GithubPushEvent{}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		wr.handlePush(event)
+	case "pull_request":
+		event := GithubPullRequestEvent{}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		wr.handlePullRequest(event)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlePush compares again the pull requests targeting the pushed branch,
+// against its new tip. Github expects a quick answer, the work is done in
+// the background. A deleted branch has no tip, its pull requests are closed
+// and removed through their own events.
+func (wr *WebhookReceiver) handlePush(event GithubPushEvent) {
+	repo, found := wr.tracked(event.Repository.FullName)
+	if !found || !strings.HasPrefix(event.Ref, "refs/heads/") {
+		return
+	}
+	if event.Deleted || strings.Trim(event.After, "0") == "" {
+		return
+	}
+	pullRequests := wr.Snapshot.PullRequestsTargeting(repo, strings.TrimPrefix(event.Ref, "refs/heads/"))
+	if len(pullRequests) == 0 {
+		return
+	}
+	go wr.update(repo, pullRequests)
+}
+
+func (wr *WebhookReceiver) handlePullRequest(event GithubPullRequestEvent) {
+	repo, found := wr.tracked(event.Repository.FullName)
+	if !found {
+		return
+	}
+	switch event.Action {
+	case "opened", "reopened", "synchronize", "edited":
+		go wr.update(repo, PullRequestList{event.PullRequest})
+	case "closed":
+		wr.Snapshot.RemovePullRequest(repo, event.PullRequest.Number)
+	}
+}
+
+func (wr *WebhookReceiver) update(repo string, pullRequests PullRequestList) {
+	if err := wr.Snapshot.UpdatePullRequests(repo, pullRequests); err != nil {
+		slog.Warn("Could not update from the webhook", "repo", repo, "error", err)
+	}
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"zen":"Keep it logically awesome."}`)
+	for _, test := range []struct {
+		secret    string
+		signature string
+		valid     bool
+	}{
+		{"secret", sign("secret", payload), true},
+		{"secret", sign("other", payload), false},
+		{"secret", strings.TrimPrefix(sign("secret", payload), "sha256="), false},
+		{"secret", "sha256=not-hex", false},
+		{"secret", "", false},
+		{"", sign("", payload), false},
+	} {
+		if valid := VerifySignature(test.secret, test.signature, payload); valid != test.valid {
+			t.Errorf("VerifySignature(%q, %q) = %t, want %t", test.secret, test.signature, valid, test.valid)
+		}
+	}
+}
+
+// fakeWebhookGithub serves the branch tips and the comparisons of the
+// repository octo/repo, a pull request being behind by the length of the
+// name of its base tip
+type fakeWebhookGithub struct {
+	lock     sync.Mutex
+	tips     map[string]string
+	requests int
+}
+
+func (f *fakeWebhookGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests++
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/repos/octo/repo/branches/"):
+		name := strings.TrimPrefix(path, "/repos/octo/repo/branches/")
+		branch := GithubBranch{Name: name}
+		branch.Commit.Sha = name + "-sha"
+		if tip, found := f.tips[name]; found {
+			branch.Commit.Sha = tip
+		}
+		json.NewEncoder(w).Encode(branch)
+	case strings.HasPrefix(path, "/repos/octo/repo/compare/"):
+		base := strings.SplitN(strings.TrimPrefix(path, "/repos/octo/repo/compare/"), "...", 2)[0]
+		json.NewEncoder(w).Encode(GithubCommitCompare{Status: "diverged", AheadBy: 1, BehindBy: len(base)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeWebhookGithub) setTip(branch string, tip string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.tips[branch] = tip
+}
+
+func (f *fakeWebhookGithub) requestCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests
+}
+
+// newWebhookReceiver tracks octo/repo, whose report has #1 targeting master
+// and #2 targeting develop. The apps made for the updates reach the fake
+// Github through the default transport.
+func newWebhookReceiver(t *testing.T) (*WebhookReceiver, *fakeWebhookGithub) {
+	fake := &fakeWebhookGithub{tips: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = githubTransport{server}
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+		server.Close()
+	})
+
+	snapshot := NewSnapshot(&Config{OauthToken: "secret", RepoAuthor: "octo", RepoName: "repo", Provider: "github"})
+	snapshot.reports = []*Report{{Repo: "octo/repo", Rows: []ReportRow{
+		{Repo: "octo/repo", Number: 1, HeadRef: "feature-1", HeadSha: "old-1", BaseRef: "master", BaseSha: "old"},
+		{Repo: "octo/repo", Number: 2, HeadRef: "feature-2", HeadSha: "old-2", BaseRef: "develop", BaseSha: "old"},
+	}}}
+	return &WebhookReceiver{Snapshot: snapshot, Secret: "webhook-secret"}, fake
+}
+
+func deliver(t *testing.T, wr *WebhookReceiver, event string, payload interface{}) int {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(string(body)))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", sign(wr.Secret, body))
+	w := httptest.NewRecorder()
+	wr.serveWebhook(w, req)
+	return w.Code
+}
+
+// waitForRow waits for the update made in the background to reach the row
+func waitForRow(t *testing.T, snapshot *Snapshot, number int, updated func(ReportRow) bool) ReportRow {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, row := range snapshot.Report("octo/repo").Rows {
+			if row.Number == number && updated(row) {
+				return row
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("#%d was not updated: %+v", number, snapshot.Report("octo/repo").Rows)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	wr, fake := newWebhookReceiver(t)
+	payload := `{"ref":"refs/heads/master","after":"new-tip","repository":{"full_name":"octo/repo"}}`
+
+	for _, signature := range []string{"", sign("other-secret", []byte(payload))} {
+		req := httptest.NewRequest("POST", "/webhook", strings.NewReader(payload))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", signature)
+		w := httptest.NewRecorder()
+		wr.serveWebhook(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("signature %q answered %d, want 401", signature, w.Code)
+		}
+	}
+	if count := fake.requestCount(); count != 0 {
+		t.Errorf("%d requests made for unsigned events", count)
+	}
+	if code := deliver(t, wr, "ping", map[string]string{"zen": "Design for failure."}); code != http.StatusAccepted {
+		t.Errorf("signed ping answered %d, want 202", code)
+	}
+}
+
+func TestWebhookPush(t *testing.T) {
+	wr, fake := newWebhookReceiver(t)
+
+	// deleted branches, tags and other repositories are ignored
+	ignored := []GithubPushEvent{
+		{Ref: "refs/heads/master", After: strings.Repeat("0", 40), Deleted: true, Repository: GithubRepo{FullName: "octo/repo"}},
+		{Ref: "refs/tags/v1.0", After: "tag-sha", Repository: GithubRepo{FullName: "octo/repo"}},
+		{Ref: "refs/heads/master", After: "new-tip", Repository: GithubRepo{FullName: "octo/other"}},
+	}
+	for _, event := range ignored {
+		if code := deliver(t, wr, "push", event); code != http.StatusAccepted {
+			t.Errorf("push %+v answered %d, want 202", event, code)
+		}
+	}
+	if count := fake.requestCount(); count != 0 {
+		t.Errorf("%d requests made for the ignored pushes", count)
+	}
+
+	fake.setTip("master", "new-tip")
+	event := GithubPushEvent{Ref: "refs/heads/master", Before: "old", After: "new-tip", Repository: GithubRepo{FullName: "Octo/Repo"}}
+	if code := deliver(t, wr, "push", event); code != http.StatusAccepted {
+		t.Fatalf("push answered %d, want 202", code)
+	}
+	row := waitForRow(t, wr.Snapshot, 1, func(row ReportRow) bool { return row.BaseSha != "old" })
+	if row.BaseSha != "new-tip" || row.HeadSha != "feature-1-sha" || row.BehindBy != len("new-tip") {
+		t.Errorf("#1 compared %s with %s, %d behind, want feature-1-sha with new-tip, %d behind", row.HeadSha, row.BaseSha, row.BehindBy, len("new-tip"))
+	}
+	if row := wr.Snapshot.Report("octo/repo").Rows[1]; row.Number != 2 || row.BaseSha != "old" {
+		t.Errorf("the push to master updated %+v", row)
+	}
+}
+
+func TestWebhookPullRequest(t *testing.T) {
+	wr, _ := newWebhookReceiver(t)
+
+	event := GithubPullRequestEvent{Action: "synchronize", Number: 2, Repository: GithubRepo{FullName: "octo/repo"}}
+	event.PullRequest = GithubPullRequest{Number: 2, Title: "Pushed again"}
+	event.PullRequest.Head.Ref = "feature-2"
+	event.PullRequest.Head.Sha = "pushed"
+	event.PullRequest.Base.Ref = "develop"
+	// the base recorded on the pull request, which is not the tip of develop
+	event.PullRequest.Base.Sha = "recorded-base"
+	if code := deliver(t, wr, "pull_request", event); code != http.StatusAccepted {
+		t.Fatalf("synchronize answered %d, want 202", code)
+	}
+	row := waitForRow(t, wr.Snapshot, 2, func(row ReportRow) bool { return row.Title == "Pushed again" })
+	if row.BaseSha != "develop-sha" || row.BehindBy != len("develop-sha") {
+		t.Errorf("#2 compared with %s, %d behind, want the tip of develop", row.BaseSha, row.BehindBy)
+	}
+
+	closed := GithubPullRequestEvent{Action: "closed", Number: 1, Repository: GithubRepo{FullName: "octo/repo"}}
+	closed.PullRequest.Number = 1
+	if code := deliver(t, wr, "pull_request", closed); code != http.StatusAccepted {
+		t.Fatalf("closed answered %d, want 202", code)
+	}
+	rows := wr.Snapshot.Report("octo/repo").Rows
+	if len(rows) != 1 || rows[0].Number != 2 {
+		t.Errorf("rows %+v after closing #1, want #2 only", rows)
+	}
+}